@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// runRecord is one run's parameters and key metrics, appended to --appendTo
+// so that a shell loop of runs builds up a dataset.
+type runRecord struct {
+	Algo             string  `json:"algo"`
+	SiteCaps         string  `json:"siteCaps"`
+	RF               int     `json:"rf"`
+	WriteStrategy    string  `json:"writeStrategy"`
+	NumWrites        int     `json:"numWrites"`
+	NumReads         int     `json:"numReads"`
+	Seed             int64   `json:"seed"`
+	UnableToWrite    int     `json:"unableToWrite"`
+	UnableToWritePct float64 `json:"unableToWritePct"`
+	ReadHitPct       float64 `json:"readHitPct"`
+	Gini             float64 `json:"gini"`
+	StddevStored     float64 `json:"stddevStored"`
+	HotspotFactor    float64 `json:"hotspotFactor"`
+	CapacityUsedPct  float64 `json:"capacityUsedPct"`
+}
+
+func newRunRecord(res result) runRecord {
+	return runRecord{
+		Algo:             *algo,
+		SiteCaps:         *siteCaps,
+		RF:               *replicationFactor,
+		WriteStrategy:    *writeStrategy,
+		NumWrites:        res.Writes,
+		NumReads:         res.Reads,
+		Seed:             *seed,
+		UnableToWrite:    res.UnableToWrite,
+		UnableToWritePct: pct(res.UnableToWrite, res.Writes),
+		ReadHitPct:       res.ReadHitPct,
+		Gini:             res.Gini,
+		StddevStored:     res.StddevStored,
+		HotspotFactor:    res.HotspotFactor,
+		CapacityUsedPct:  res.CapacityUsedPct,
+	}
+}
+
+var runRecordHeader = []string{"algo", "site_caps", "rf", "write_strategy", "num_writes", "num_reads", "seed", "unable_to_write", "unable_to_write_pct", "read_hit_pct", "gini", "stddev_stored", "hotspot_factor", "capacity_used_pct"}
+
+func (r runRecord) csvRow() []string {
+	f := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+	return []string{
+		r.Algo,
+		r.SiteCaps,
+		strconv.Itoa(r.RF),
+		r.WriteStrategy,
+		strconv.Itoa(r.NumWrites),
+		strconv.Itoa(r.NumReads),
+		strconv.FormatInt(r.Seed, 10),
+		strconv.Itoa(r.UnableToWrite),
+		f(r.UnableToWritePct),
+		f(r.ReadHitPct),
+		f(r.Gini),
+		f(r.StddevStored),
+		f(r.HotspotFactor),
+		f(r.CapacityUsedPct),
+	}
+}
+
+// appendRun appends res as one record to the file at path, creating it if
+// need be: a CSV row if path ends in .csv, with a header when the file is new
+// or empty, otherwise a line of JSON.
+func appendRun(path string, res result) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	rec := newRunRecord(res)
+	if filepath.Ext(path) != ".csv" {
+		if err := json.NewEncoder(f).Encode(rec); err != nil {
+			return err
+		}
+		return f.Close()
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	cw := csv.NewWriter(f)
+	if info.Size() == 0 {
+		cw.Write(runRecordHeader)
+	}
+	cw.Write(rec.csvRow())
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// loadBaseline reads the result of an earlier run, as written by --output json,
+// for --baseline. Output from before the schema was versioned is a bare
+// result.
+func loadBaseline(path string) (result, error) {
+	var base jsonResult
+	b, err := os.ReadFile(path)
+	if err == nil {
+		err = json.Unmarshal(b, &base)
+	}
+	if err == nil {
+		switch base.SchemaVersion {
+		case 0:
+			err = json.Unmarshal(b, &base.Summary)
+		case jsonSchemaVersion:
+			base.Summary.Sites = base.Sites
+		default:
+			err = fmt.Errorf("schema version %d is newer than this tool's %d", base.SchemaVersion, jsonSchemaVersion)
+		}
+	}
+	if err != nil {
+		return result{}, fmt.Errorf("reading --baseline %s: %v", path, err)
+	}
+	return base.Summary, nil
+}
+
+// printBaselineDiff writes how res differs from base: the change in each
+// cluster-wide metric, then in each site's, matching sites by id. Sites in
+// only one of the runs are shown as added or removed.
+func printBaselineDiff(w io.Writer, base, res result) error {
+	fmt.Fprintln(w, "against baseline:")
+	fmt.Fprintf(w, "  unable to write: %d -> %d (%+d)\n", base.UnableToWrite, res.UnableToWrite, res.UnableToWrite-base.UnableToWrite)
+	fmt.Fprintf(w, "  read hit rate: %.2f%% -> %.2f%% (%+.2f)\n", base.ReadHitPct, res.ReadHitPct, res.ReadHitPct-base.ReadHitPct)
+	fmt.Fprintf(w, "  load stddev: %.2f -> %.2f keys (%+.2f)\n", base.StddevStored, res.StddevStored, res.StddevStored-base.StddevStored)
+	fmt.Fprintf(w, "  gini: %.4f -> %.4f (%+.4f)\n", base.Gini, res.Gini, res.Gini-base.Gini)
+	_, err := fmt.Fprintf(w, "  hotspot factor: %.2fx -> %.2fx (%+.2f)\n", base.HotspotFactor, res.HotspotFactor, res.HotspotFactor-base.HotspotFactor)
+
+	before := make(map[int]SiteStat)
+	for _, s := range base.Sites {
+		before[s.ID] = s
+	}
+	for _, s := range res.Sites {
+		b, ok := before[s.ID]
+		if !ok {
+			_, err = fmt.Fprintf(w, "  site %s: added, %.2f%% full, %d hits, %d misses\n", siteLabel(s.ID, s.Name), s.FullnessPct, s.ReadHits, s.ReadMisses)
+			continue
+		}
+		delete(before, s.ID)
+		_, err = fmt.Fprintf(w, "  site %s: fullness %+.2f%%, hits %+d, misses %+d\n", siteLabel(s.ID, s.Name), s.FullnessPct-b.FullnessPct, s.ReadHits-b.ReadHits, s.ReadMisses-b.ReadMisses)
+	}
+	for _, b := range base.Sites {
+		if _, ok := before[b.ID]; ok {
+			_, err = fmt.Fprintf(w, "  site %s: removed\n", siteLabel(b.ID, b.Name))
+		}
+	}
+	return err
+}
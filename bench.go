@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+
+	"example.com/mod/hashing"
+)
+
+// runBench times placing every key on the ring without writing it, and prints
+// the cost per placement. For rendezvous rings it also times TopSites and
+// PlaceBatch, to compare against placing keys one at a time. With --workers it
+// also times placing keys across that many goroutines.
+func runBench(ring placer, keys []string) {
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	for _, key := range keys {
+		ring.OrderedSites(key)
+	}
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+
+	fmt.Printf("%d placements over %d sites in %v\n", len(keys), len(ring.Sites()), elapsed)
+	printBench("OrderedSites", len(keys), elapsed, before, after)
+
+	if *workers > 1 {
+		sim := newSimulation(ring)
+		runtime.ReadMemStats(&before)
+		start = time.Now()
+		sim.placeParallel(keys)
+		elapsed = time.Since(start)
+		runtime.ReadMemStats(&after)
+		printBench(fmt.Sprintf("parallel placement(workers=%d)", *workers), len(keys), elapsed, before, after)
+	}
+
+	r, ok := ring.(*hashing.Ring)
+	if !ok {
+		return
+	}
+	runtime.ReadMemStats(&before)
+	start = time.Now()
+	for _, key := range keys {
+		r.TopSites(key, *replicationFactor)
+	}
+	elapsed = time.Since(start)
+	runtime.ReadMemStats(&after)
+	printBench(fmt.Sprintf("TopSites(rf=%d)", *replicationFactor), len(keys), elapsed, before, after)
+
+	runtime.ReadMemStats(&before)
+	start = time.Now()
+	r.PlaceBatch(keys, *replicationFactor)
+	elapsed = time.Since(start)
+	runtime.ReadMemStats(&after)
+	printBench(fmt.Sprintf("PlaceBatch(rf=%d)", *replicationFactor), len(keys), elapsed, before, after)
+}
+
+func printBench(name string, placements int, elapsed time.Duration, before, after runtime.MemStats) {
+	n := float64(placements)
+	fmt.Printf("%s: %.0f ns/placement, %.0f placements/sec, %.1f allocs/placement, %.0f B/placement\n",
+		name,
+		float64(elapsed.Nanoseconds())/n,
+		n/elapsed.Seconds(),
+		float64(after.Mallocs-before.Mallocs)/n,
+		float64(after.TotalAlloc-before.TotalAlloc)/n)
+}
+
+// throughputSampleEvery is how often a --throughput worker records a
+// placement's latency. Timing every placement would cost about as much as
+// the placement, and keeping every latency would use a lot of memory.
+const throughputSampleEvery = 16
+
+// runThroughput has --workers goroutines place keys, cycling through keys, for
+// d of wall-clock time, and prints the placements per second, the p99
+// placement latency, and the allocation rate. Each placement also reads the
+// key from its primary site, so workers contend on the sites' shared stats.
+func runThroughput(ring placer, keys []string, d time.Duration) {
+	workers := max(*workers, 1)
+	counts := make([]int, workers)
+	latencies := make([][]float64, workers)
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	deadline := time.Now().Add(d)
+	start := time.Now()
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; ; i += workers {
+				// Checking the clock is about as costly as placing, so
+				// check it only every so often.
+				if counts[w]%throughputSampleEvery == 0 && !time.Now().Before(deadline) {
+					return
+				}
+				key := keys[i%len(keys)]
+				if counts[w]%throughputSampleEvery != 0 {
+					place(ring, key)
+				} else {
+					t := time.Now()
+					place(ring, key)
+					latencies[w] = append(latencies[w], float64(time.Since(t).Nanoseconds()))
+				}
+				counts[w]++
+			}
+		}(w)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+
+	var total int
+	var sampled []float64
+	for w := range counts {
+		total += counts[w]
+		sampled = append(sampled, latencies[w]...)
+	}
+	if total == 0 {
+		fmt.Printf("no placements over %d sites, workers=%d, in %v\n", len(ring.Sites()), workers, elapsed.Round(time.Millisecond))
+		return
+	}
+	p99 := percentilesOf(sampled, 99)[99]
+	fmt.Printf("%d placements over %d sites, workers=%d, in %v: %.0f placements/sec, p99 latency %v\n", total, len(ring.Sites()), workers, elapsed.Round(time.Millisecond), float64(total)/elapsed.Seconds(), time.Duration(p99))
+	fmt.Printf("allocation: %.0f B/sec, %.1f allocs/placement, %.0f B/placement\n",
+		float64(after.TotalAlloc-before.TotalAlloc)/elapsed.Seconds(),
+		float64(after.Mallocs-before.Mallocs)/float64(total),
+		float64(after.TotalAlloc-before.TotalAlloc)/float64(total))
+}
+
+// place finds key's top --rf sites and reads it from the primary.
+func place(ring placer, key string) {
+	if top := ring.TopSites(key, *replicationFactor); len(top) > 0 {
+		top[0].HandleRead(key)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// parseSiteCaps parses a comma separated list of site capacities. Spaces
+// around capacities and empty fields, such as from a trailing comma, are
+// ignored. Negative capacities and those over max are rejected here, before
+// --capMode weight can split a total by them; whether zero capacities are
+// allowed is left to validateConfig.
+func parseSiteCaps(s string, max int64) ([]int, error) {
+	var caps []int
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		c, err := strconv.ParseInt(f, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid site capacity %q: %v", f, err)
+		}
+		if c < 0 {
+			return nil, fmt.Errorf("invalid site capacity %q: must not be negative", f)
+		}
+		if c > max {
+			return nil, fmt.Errorf("invalid site capacity %q: over --maxCap %d", f, max)
+		}
+		caps = append(caps, int(c))
+	}
+	if len(caps) == 0 {
+		return nil, fmt.Errorf("--siteCaps %q lists no sites", s)
+	}
+	return caps, nil
+}
+
+// weightedCapacities splits total between sites in proportion to their
+// weights. Capacities are rounded down and the keys left over go one each to
+// the sites with the largest remainders, earlier sites first on ties, so the
+// capacities always sum to total. Each weight times total is worked out in
+// 128 bits, so it can't overflow however large total is. A capacity over max
+// is rejected, as it would be had it been given by --siteCaps.
+func weightedCapacities(weights []int, total int, max int64) ([]int, error) {
+	var sum uint64
+	for _, w := range weights {
+		sum += uint64(w)
+	}
+	caps := make([]int, len(weights))
+	if sum == 0 {
+		return caps, nil
+	}
+
+	// Each quotient is at most total, since w <= sum, so fits in an int.
+	remainders := make([]int64, len(weights))
+	left := total
+	for i, w := range weights {
+		hi, lo := bits.Mul64(uint64(w), uint64(total))
+		q, r := bits.Div64(hi, lo, sum)
+		caps[i], remainders[i] = int(q), int64(r)
+		left -= caps[i]
+	}
+	for ; left > 0; left-- {
+		best := 0
+		for i := range remainders {
+			if remainders[i] > remainders[best] {
+				best = i
+			}
+		}
+		caps[best]++
+		remainders[best] = -1
+	}
+	for i, c := range caps {
+		if int64(c) > max {
+			return nil, fmt.Errorf("--totalCapacity %d gives site %d capacity %d, over --maxCap %d", total, i+1, c, max)
+		}
+	}
+	return caps, nil
+}
+
+// parseSiteNames parses a comma separated list of distinct names, one for each
+// of n sites listed by --siteCaps. Spaces around names are ignored. Flags that
+// take a site accept its name or id, so names that are integers are rejected
+// rather than risk naming a different site than the id.
+func parseSiteNames(s string, n int) ([]string, error) {
+	var names []string
+	seen := make(map[string]bool)
+	for _, f := range strings.Split(s, ",") {
+		name := strings.TrimSpace(f)
+		if name == "" {
+			return nil, fmt.Errorf("--siteNames %q has an empty name", s)
+		}
+		if _, err := strconv.Atoi(name); err == nil {
+			return nil, fmt.Errorf("--siteNames %q has a name %q that could be mistaken for a site id", s, name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("--siteNames %q names more than one site %q", s, name)
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	if len(names) != n {
+		return nil, fmt.Errorf("--siteNames lists %d names for %d sites", len(names), n)
+	}
+	return names, nil
+}
+
+// parseSiteLatencies parses a comma separated list of non-negative read
+// latencies in milliseconds, one for each of n sites listed by --siteCaps.
+func parseSiteLatencies(s string, n int) ([]float64, error) {
+	var latencies []float64
+	for _, f := range strings.Split(s, ",") {
+		l, err := strconv.ParseFloat(strings.TrimSpace(f), 64)
+		if err != nil || l < 0 {
+			return nil, fmt.Errorf("invalid site latency %q: want a non-negative number of milliseconds", f)
+		}
+		latencies = append(latencies, l)
+	}
+	if len(latencies) != n {
+		return nil, fmt.Errorf("--siteLatencies lists %d latencies for %d sites", len(latencies), n)
+	}
+	return latencies, nil
+}
+
+// parseSiteZones parses a comma separated list of zones, one for each of n
+// sites listed by --siteCaps. Spaces around zones are ignored.
+func parseSiteZones(s string, n int) ([]string, error) {
+	var zones []string
+	for _, f := range strings.Split(s, ",") {
+		zones = append(zones, strings.TrimSpace(f))
+	}
+	if len(zones) != n {
+		return nil, fmt.Errorf("--siteZones lists %d zones for %d sites", len(zones), n)
+	}
+	for _, z := range zones {
+		if z == "" {
+			return nil, fmt.Errorf("--siteZones %q has an empty zone", s)
+		}
+	}
+	return zones, nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Config is the simulation parameters that can be loaded from a --config
+// file. Each field's JSON name is the flag it sets; fields left out of the
+// file leave their flag alone.
+type Config struct {
+	SiteCaps      []int    `json:"siteCaps"`
+	CapMode       *string  `json:"capMode"`
+	TotalCapacity *int     `json:"totalCapacity"`
+	RF            *int     `json:"rf"`
+	NumWrites     *int     `json:"numWrites"`
+	NumReads      *int     `json:"numReads"`
+	MixedOps      *int     `json:"mixedOps"`
+	ReadRatio     *float64 `json:"readRatio"`
+	ReadDist      *string  `json:"readDist"`
+	WriteDist     *string  `json:"writeDist"`
+	KeySpace      *int     `json:"keySpace"`
+	ZipfS         *float64 `json:"zipfS"`
+	ZipfV         *float64 `json:"zipfV"`
+	Namespaces    *int     `json:"namespaces"`
+	Algo          *string  `json:"algo"`
+	Hash          *string  `json:"hash"`
+	Weighted      *bool    `json:"weighted"`
+	VNodes        *int     `json:"vnodes"`
+	Seed          *int64   `json:"seed"`
+	WriteStrategy *string  `json:"writeStrategy"`
+	OnFull        *string  `json:"onFull"`
+	Output        *string  `json:"output"`
+}
+
+// loadConfig reads the JSON config file at path and sets the flags it gives
+// values for, except those passed on the command line, which take precedence.
+func loadConfig(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var cfg Config
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cfg); err != nil {
+		return fmt.Errorf("reading %s: %v", path, err)
+	}
+
+	v := reflect.ValueOf(cfg)
+	for i := 0; i < v.NumField(); i++ {
+		name := v.Type().Field(i).Tag.Get("json")
+		field := v.Field(i)
+		if field.IsNil() || flagSet(name) {
+			continue
+		}
+		var value string
+		if caps, ok := field.Interface().([]int); ok {
+			fields := make([]string, len(caps))
+			for j, c := range caps {
+				fields[j] = strconv.Itoa(c)
+			}
+			value = strings.Join(fields, ",")
+		} else {
+			value = fmt.Sprint(field.Elem().Interface())
+		}
+		if err := flag.Set(name, value); err != nil {
+			return fmt.Errorf("%s: %s: %v", path, name, err)
+		}
+	}
+	return nil
+}
+
+// currentConfig returns the flags' current values as a Config, the inverse of
+// loadConfig, so a run's output can record how to repeat it. The hash and
+// seed are recorded as they took effect, by effectiveConfig.
+func currentConfig() Config {
+	var cfg Config
+	v := reflect.ValueOf(&cfg).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		f := flag.Lookup(v.Type().Field(i).Tag.Get("json"))
+		field := v.Field(i)
+		if field.Kind() == reflect.Slice {
+			for _, c := range strings.Split(f.Value.String(), ",") {
+				if n, err := strconv.Atoi(strings.TrimSpace(c)); err == nil {
+					cfg.SiteCaps = append(cfg.SiteCaps, n)
+				}
+			}
+			continue
+		}
+		value := reflect.New(field.Type().Elem())
+		value.Elem().Set(reflect.ValueOf(f.Value.(flag.Getter).Get()))
+		field.Set(value)
+	}
+	return effectiveConfig(cfg, flagSet("seed"))
+}
+
+// effectiveConfig returns cfg with its hash and seed as a run with them used
+// them. An unseeded run records no seed, since --config setting one would
+// seed the rerun, and a seeded run records the hash newSeededHasher used in
+// place of the one asked for.
+func effectiveConfig(cfg Config, seeded bool) Config {
+	if !seeded {
+		cfg.Seed = nil
+		return cfg
+	}
+	if cfg.Hash != nil {
+		hash := seededHashName(*cfg.Hash)
+		cfg.Hash = &hash
+	}
+	return cfg
+}
@@ -0,0 +1,185 @@
+// Package consistent implements classic consistent hashing with virtual nodes,
+// for comparison against rendezvous hashing.
+//
+// https://en.wikipedia.org/wiki/Consistent_hashing
+package consistent
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"example.com/mod/hashing"
+)
+
+// baseVNodes is the number of virtual nodes given to the smallest site a ring
+// is built with. Larger sites get proportionally more.
+const baseVNodes = 100
+
+// maxVNodes caps the virtual nodes a ring builds, so that a site vastly larger
+// than the smallest doesn't get billions. Past it, every site's count is
+// scaled down together, keeping them proportional to capacity, though each
+// site with capacity keeps at least one.
+const maxVNodes = 1 << 20
+
+type vnode struct {
+	hash uint64
+	site *hashing.Site
+}
+
+// Ring is a sorted ring of virtual nodes, each owned by a site. A site's
+// virtual node count scales with its capacity.
+type Ring struct {
+	sites             []*hashing.Site
+	hasher            hashing.Hasher
+	vnodesPerCapacity float64
+	vnodes            []vnode
+}
+
+func NewRing(hasher hashing.Hasher, sites []*hashing.Site) *Ring {
+	minCap := 0
+	for _, s := range sites {
+		if s.Capacity() > 0 && (minCap == 0 || s.Capacity() < minCap) {
+			minCap = s.Capacity()
+		}
+	}
+	if minCap == 0 {
+		minCap = 1
+	}
+	r := &Ring{sites: sites, hasher: hasher, vnodesPerCapacity: baseVNodes / float64(minCap)}
+	r.build()
+	return r
+}
+
+// withSites returns a ring over sites that sizes virtual nodes like r.
+func (r *Ring) withSites(sites []*hashing.Site) *Ring {
+	o := &Ring{sites: sites, hasher: r.hasher, vnodesPerCapacity: r.vnodesPerCapacity}
+	o.build()
+	return o
+}
+
+func (r *Ring) build() {
+	var total float64
+	for _, s := range r.sites {
+		total += float64(max(s.Capacity(), 0))
+	}
+	perCapacity := r.vnodesPerCapacity
+	if total*perCapacity > maxVNodes {
+		perCapacity = maxVNodes / total
+	}
+	r.vnodes = r.vnodes[:0]
+	for _, s := range r.sites {
+		n := int(math.Ceil(float64(s.Capacity()) * perCapacity))
+		for i := 0; i < n; i++ {
+			hashKey := fmt.Sprintf("%d-%d", s.ID(), i)
+			r.vnodes = append(r.vnodes, vnode{hash: r.hasher.Hash([]byte(hashKey)), site: s})
+		}
+	}
+	sort.Slice(r.vnodes, func(i, j int) bool {
+		return r.vnodes[i].hash < r.vnodes[j].hash
+	})
+}
+
+func (r *Ring) Sites() []*hashing.Site {
+	return r.sites
+}
+
+// AddSite adds s to the ring.
+func (r *Ring) AddSite(s *hashing.Site) {
+	r.sites = append(r.sites, s)
+	r.build()
+}
+
+// RemoveSite removes the site with the given id from the ring and returns it,
+// or nil if the ring has no such site. Slices previously returned by Sites are
+// left untouched.
+func (r *Ring) RemoveSite(id int) *hashing.Site {
+	for i, s := range r.sites {
+		if s.ID() == id {
+			r.sites = append(r.sites[:i:i], r.sites[i+1:]...)
+			r.build()
+			return s
+		}
+	}
+	return nil
+}
+
+// RemapFraction returns the fraction of keys whose primary site differs
+// between the before and after memberships.
+func (r *Ring) RemapFraction(keys []string, before, after []*hashing.Site) float64 {
+	if len(keys) == 0 {
+		return 0
+	}
+	br, ar := r.withSites(before), r.withSites(after)
+	var moved int
+	for _, key := range keys {
+		b, a := br.OrderedSites(key), ar.OrderedSites(key)
+		if len(b) == 0 || len(a) == 0 || b[0] != a[0] {
+			moved++
+		}
+	}
+	return float64(moved) / float64(len(keys))
+}
+
+// Verify checks that each of keys is held by exactly its top rf sites, and
+// returns an error for each key that isn't.
+func (r *Ring) Verify(keys []string, rf int) []error {
+	return hashing.VerifyPlacement(r.sites, r.TopSites, keys, rf)
+}
+
+// Rebalance moves each of keys onto its current top rf sites, returning the
+// number of key copies written. See hashing.RebalancePlacement.
+func (r *Ring) Rebalance(keys []string, rf int) (moved int) {
+	return hashing.RebalancePlacement(r.sites, r.TopSites, keys, rf)
+}
+
+// WarmNewSite copies onto s each of keys it is now one of the top rf sites
+// for, returning the number copied. See hashing.WarmSite.
+func (r *Ring) WarmNewSite(s *hashing.Site, keys []string, rf int) (copied int) {
+	return hashing.WarmSite(s, r.TopSites, keys, rf)
+}
+
+// Reset empties every site on the ring. See hashing.ResetSites.
+func (r *Ring) Reset() {
+	hashing.ResetSites(r.sites)
+}
+
+// OrderedSites returns the ring's sites in the order they are first met
+// walking clockwise from key's position on the ring.
+func (r *Ring) OrderedSites(key string) []*hashing.Site {
+	return r.TopSites(key, len(r.sites))
+}
+
+// TopSites returns the first n sites met walking clockwise from key's position
+// on the ring.
+func (r *Ring) TopSites(key string, n int) []*hashing.Site {
+	if n > len(r.sites) {
+		n = len(r.sites)
+	}
+	ordered := make([]*hashing.Site, 0, n)
+	seen := make(map[*hashing.Site]bool, n)
+	if len(r.vnodes) > 0 {
+		h := r.hasher.Hash([]byte(key))
+		start := sort.Search(len(r.vnodes), func(i int) bool {
+			return r.vnodes[i].hash >= h
+		})
+		for i := 0; i < len(r.vnodes) && len(ordered) < n; i++ {
+			v := r.vnodes[(start+i)%len(r.vnodes)]
+			if !seen[v.site] {
+				seen[v.site] = true
+				ordered = append(ordered, v.site)
+			}
+		}
+	}
+	// Small sites may not have been met if there are few virtual nodes.
+	// Sites without capacity are decommissioned and never placed on.
+	for _, s := range r.sites {
+		if len(ordered) == n {
+			break
+		}
+		if !seen[s] && s.Capacity() > 0 {
+			ordered = append(ordered, s)
+		}
+	}
+	return ordered
+}
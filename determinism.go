@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"slices"
+	"sort"
+
+	"example.com/mod/hashing"
+)
+
+// placementCheck records the sites a sample of keys were first written to,
+// for checkPlacements to compare with where they place after the run.
+type placementCheck struct {
+	// written maps each sampled key to the ids of its top replicationFactor
+	// sites when first written, or nil if it hasn't been yet.
+	written map[string][]int
+}
+
+// newPlacementCheck samples n distinct keys uniformly from keys.
+func newPlacementCheck(keys []string, n int, rng *rand.Rand) *placementCheck {
+	keys = distinctKeys(keys)
+	pc := &placementCheck{written: make(map[string][]int, min(n, len(keys)))}
+	for _, i := range rng.Perm(len(keys))[:min(n, len(keys))] {
+		pc.written[keys[i]] = nil
+	}
+	return pc
+}
+
+// record notes the sites key is written to, if it's sampled and this is its
+// first write.
+func (pc *placementCheck) record(key string, candidates []*hashing.Site) {
+	if ids, ok := pc.written[key]; !ok || ids != nil {
+		return
+	}
+	pc.written[key] = topIDs(candidates)
+}
+
+// topIDs returns the ids of the first replicationFactor of sites.
+func topIDs(sites []*hashing.Site) []int {
+	ids := make([]int, 0, *replicationFactor)
+	for _, s := range sites[:min(*replicationFactor, len(sites))] {
+		ids = append(ids, s.ID())
+	}
+	return ids
+}
+
+// checkPlacements re-places each sampled key that was written and returns an
+// error for each whose sites differ from those it was written to. Membership
+// and capacities are fixed for runs that check, so any difference means
+// placement isn't deterministic.
+func (sim *simulation) checkPlacements() []error {
+	keys := make([]string, 0, len(sim.placements.written))
+	for key, ids := range sim.placements.written {
+		if ids != nil {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	var errs []error
+	for _, key := range keys {
+		want := sim.placements.written[key]
+		if got := topIDs(sim.candidates(key)); !slices.Equal(got, want) {
+			errs = append(errs, fmt.Errorf("key %s was written to sites %v but now places on %v", key, want, got))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"example.com/mod/hashing"
+)
+
+// disruptionRow is the effect on placement of removing one site.
+type disruptionRow struct {
+	ID   int    `json:"id"`
+	Name string `json:"name,omitempty"`
+	// SharePct is the percentage of keys the site is a top --rf site for,
+	// and AffectedPct the percentage whose top sites change on removing it.
+	// They should be equal.
+	SharePct    float64 `json:"sharePct"`
+	AffectedPct float64 `json:"affectedPct"`
+	// Moved counts keys whose surviving top sites changed, which minimal
+	// disruption says should never happen.
+	Moved int `json:"moved"`
+}
+
+// runDisruption removes each site with capacity from ring in turn, placing the
+// distinct keys on a new ring of the remaining sites, and returns how many
+// keys each removal affected. Only keys the removed site was a top --rf site
+// for should change placement, and those should keep their other sites in
+// order. With --replicaPlacement spread, replicas are compared instead of top
+// sites, and since removing a site shifts the ranks they're picked at, keys
+// are expected to move. Jump hashing can only remove the last site, so under
+// --algo jump only that removal is tried.
+func runDisruption(ring placer, hasher hashing.Hasher, keys []string) ([]disruptionRow, error) {
+	sites := ring.Sites()
+	removals := sites
+	if *algo == "jump" && len(sites) > 0 {
+		removals = sites[len(sites)-1:]
+	}
+	keys = distinctKeys(keys)
+	before := make([][]*hashing.Site, len(keys))
+	for i, key := range keys {
+		before[i] = replicas(ring, key)
+	}
+
+	var rows []disruptionRow
+	for _, removed := range removals {
+		if removed.Capacity() <= 0 {
+			continue
+		}
+		var rest []*hashing.Site
+		for _, s := range sites {
+			if s != removed {
+				rest = append(rest, s)
+			}
+		}
+		after, err := newPlacer(*algo, hasher, rest)
+		if err != nil {
+			return nil, err
+		}
+		row := disruptionRow{ID: removed.ID(), Name: removed.Name()}
+		var held, affected int
+		for i, key := range keys {
+			top := replicas(after, key)
+			var survivors []*hashing.Site
+			for _, s := range before[i] {
+				if s != removed {
+					survivors = append(survivors, s)
+				}
+			}
+			if len(survivors) < len(before[i]) {
+				held++
+			}
+			if !sameSites(before[i], top) {
+				affected++
+			}
+			if !sameSites(survivors, top[:min(len(survivors), len(top))]) {
+				row.Moved++
+			}
+		}
+		if len(keys) > 0 {
+			row.SharePct = float64(held) / float64(len(keys)) * 100
+			row.AffectedPct = float64(affected) / float64(len(keys)) * 100
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// distinctKeys returns keys without repeats, in the order first seen.
+func distinctKeys(keys []string) []string {
+	var distinct []string
+	seen := make(map[string]struct{})
+	for _, key := range keys {
+		if _, ok := seen[key]; !ok {
+			seen[key] = struct{}{}
+			distinct = append(distinct, key)
+		}
+	}
+	return distinct
+}
+
+// sameSites reports whether a and b hold the same sites in the same order.
+func sameSites(a, b []*hashing.Site) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// printDisruption writes rows as a table, or as JSON with --output json.
+func printDisruption(w io.Writer, rows []disruptionRow) error {
+	if *output == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(rows)
+	}
+	fmt.Fprintf(w, "%-10s %-10s %-10s %s\n", "removed", "share", "affected", "moved")
+	var err error
+	for _, r := range rows {
+		_, err = fmt.Fprintf(w, "%-10s %-10s %-10s %d\n", siteLabel(r.ID, r.Name), fmt.Sprintf("%.2f%%", r.SharePct), fmt.Sprintf("%.2f%%", r.AffectedPct), r.Moved)
+	}
+	return err
+}
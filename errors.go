@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Exit codes, so that scripts can tell failures apart without reading the
+// message, which goes to stderr.
+const (
+	// exitFailure is for a run that failed, such as a file that couldn't be
+	// read or written.
+	exitFailure = 1
+	// exitBadFlags is for a flag whose value couldn't be parsed or isn't
+	// one of its options. The flag package exits with it too.
+	exitBadFlags = 2
+	// exitBadConfig is for flags that parse but describe a simulation that
+	// can't run, as reported by validateConfig.
+	exitBadConfig = 3
+	// exitCheckFailed is for a check that ran and failed: --verify,
+	// --checkPlacement or --disruptionTest.
+	exitCheckFailed = 4
+)
+
+// exitCodesUsage documents the exit codes, after the flags in --help.
+const exitCodesUsage = `
+Exit codes:
+  0  success
+  1  the run failed, e.g. a file couldn't be read or written
+  2  a flag's value is invalid
+  3  the flags describe a simulation that can't run
+  4  --verify, --checkPlacement or --disruptionTest failed
+`
+
+// simError is an error that exits with a particular code.
+type simError struct {
+	code int
+	msg  string
+}
+
+func (e *simError) Error() string {
+	return e.msg
+}
+
+// flagError marks err as caused by an invalid flag value.
+func flagError(err error) error {
+	return &simError{code: exitBadFlags, msg: err.Error()}
+}
+
+// flagErrorf is like flagError but formats a new error.
+func flagErrorf(format string, a ...interface{}) error {
+	return &simError{code: exitBadFlags, msg: fmt.Sprintf(format, a...)}
+}
+
+// checkFailed returns an error for a failed check.
+func checkFailed(format string, a ...interface{}) error {
+	return &simError{code: exitCheckFailed, msg: fmt.Sprintf(format, a...)}
+}
+
+// exitCode returns the code to exit with for err.
+func exitCode(err error) int {
+	var se *simError
+	var ce *configError
+	switch {
+	case errors.As(err, &se):
+		return se.code
+	case errors.As(err, &ce):
+		return exitBadConfig
+	}
+	return exitFailure
+}
+
+// exit writes err to stderr and exits with its code.
+func exit(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(exitCode(err))
+}
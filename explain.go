@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"example.com/mod/hashing"
+)
+
+// explain prints every site in key's preference order along with its
+// rendezvous score, marking the sites a write would place key on.
+func explain(w io.Writer, ring placer, key string) error {
+	r, ok := ring.(*hashing.Ring)
+	if !ok {
+		return fmt.Errorf("explain only supports --algo rendezvous")
+	}
+	fmt.Fprintf(w, "key %s:\n", key)
+	for i, s := range r.ScoredSites(key) {
+		fmt.Fprintf(w, "site %s: score=%.4g", s.Site.Label(), s.Score)
+		switch {
+		case i == 0:
+			fmt.Fprint(w, " (primary)")
+		case i < *replicationFactor:
+			fmt.Fprint(w, " (replica)")
+		}
+		fmt.Fprintln(w)
+	}
+	return nil
+}
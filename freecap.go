@@ -0,0 +1,55 @@
+package main
+
+import (
+	"math/rand"
+
+	"example.com/mod/hashing"
+)
+
+// scorerComparison is how evenly the classic, capacity-weighted scorer loads
+// sites over the same run, for --scoreVariant freecap to be compared with.
+type scorerComparison struct {
+	UnableToWrite int     `json:"unableToWrite"`
+	StddevStored  float64 `json:"stddevStored"`
+	Gini          float64 `json:"gini"`
+	HotspotFactor float64 `json:"hotspotFactor"`
+}
+
+// compareClassic runs the simulation again with --scoreVariant classic, on
+// fresh sites like sites with failed going offline as before, and reads drawn
+// from an rng seeded with rngSeed.
+func compareClassic(sites []*hashing.Site, hasher hashing.Hasher, keys []string, rngSeed int64, failed []int) (*scorerComparison, error) {
+	defer func(variant string) { *scoreVariant = variant }(*scoreVariant)
+	*scoreVariant = "classic"
+	caps := make([]int, len(sites))
+	for i, s := range sites {
+		caps[i] = s.Capacity()
+	}
+	ring, err := newPlacer(*algo, hasher, newSitesLike(sites, caps))
+	if err != nil {
+		return nil, err
+	}
+	rng := rand.New(rand.NewSource(rngSeed))
+	nextWrite, err := newWriteDist(*writeDist, rng)
+	if err != nil {
+		return nil, err
+	}
+	nextReadKey, err := newReadDist(*readDist, keys, nextWrite, rng)
+	if err != nil {
+		return nil, err
+	}
+
+	sim := newSimulation(ring)
+	sim.failed = failed
+	sim.distinctWrites = len(distinctKeys(keys)) == len(keys)
+	if *mixedOps > 0 {
+		err = sim.runMixed(keys, rng)
+	} else {
+		err = sim.run(keys, nextReadKey)
+	}
+	if err != nil {
+		return nil, err
+	}
+	res := sim.result()
+	return &scorerComparison{UnableToWrite: res.UnableToWrite, StddevStored: res.StddevStored, Gini: res.Gini, HotspotFactor: res.HotspotFactor}, nil
+}
@@ -1 +1,3 @@
 module example.com/mod
+
+go 1.27.1
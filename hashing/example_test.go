@@ -0,0 +1,31 @@
+package hashing_test
+
+import (
+	"fmt"
+
+	"example.com/mod/hashing"
+)
+
+func ExampleRing() {
+	sites := []*hashing.Site{hashing.NewSite(1, 100), hashing.NewSite(2, 200)}
+	ring := hashing.NewRing(hashing.NewSeeded(1, hashing.FNV{}), sites)
+	ring.AddSite(hashing.NewSite(3, 300))
+
+	// Write the key to its two most preferred sites.
+	replicas := ring.TopSites("key", 2)
+	for _, s := range replicas {
+		s.HandleWrite("key")
+	}
+	for _, s := range ring.OrderedSites("key") {
+		fmt.Printf("site %d has key: %t\n", s.ID(), s.HandleRead("key"))
+	}
+
+	// Place gives the same placement by site id, for callers that keep it.
+	p := ring.Place("key", 2)
+	fmt.Println("primary:", p.Primary, "replicas:", p.Replicas)
+	// Output:
+	// site 1 has key: true
+	// site 3 has key: true
+	// site 2 has key: false
+	// primary: 1 replicas: [1 3]
+}
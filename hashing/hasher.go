@@ -0,0 +1,112 @@
+package hashing
+
+import (
+	"encoding/binary"
+	"hash/crc64"
+	"hash/maphash"
+	"sync"
+)
+
+// Hasher produces the raw hash that sites are scored by for a key.
+type Hasher interface {
+	Hash(data []byte) uint64
+}
+
+// MapHash hashes with hash/maphash. Hashes are only comparable between
+// MapHashes with the same seed.
+type MapHash struct {
+	seed maphash.Seed
+}
+
+func NewMapHash(seed maphash.Seed) *MapHash {
+	return &MapHash{seed: seed}
+}
+
+func (h *MapHash) Hash(data []byte) uint64 {
+	return maphash.Bytes(h.seed, data)
+}
+
+// FNV hashes with 64-bit FNV-1a.
+type FNV struct{}
+
+func (FNV) Hash(data []byte) uint64 {
+	return fnvUpdate(fnvOffset, data)
+}
+
+// fnvOffset and fnvPrime are 64-bit FNV-1a's parameters, as in hash/fnv.
+const (
+	fnvOffset = 14695981039346656037
+	fnvPrime  = 1099511628211
+)
+
+// fnvUpdate continues the FNV-1a hash h over data. Unlike hash/fnv, it
+// doesn't allocate, and lets Seeded hash its seed once.
+func fnvUpdate(h uint64, data []byte) uint64 {
+	for _, c := range data {
+		h ^= uint64(c)
+		h *= fnvPrime
+	}
+	return h
+}
+
+var crc64Table = crc64.MakeTable(crc64.ECMA)
+
+// CRC64 hashes with the ECMA CRC-64 checksum.
+type CRC64 struct{}
+
+func (CRC64) Hash(data []byte) uint64 {
+	return crc64.Checksum(data, crc64Table)
+}
+
+// Seeded hashes the data prefixed with a fixed seed, so that runs using the
+// same seed place keys identically. It exists because a maphash.Seed can't be
+// derived from a value. The wrapped hash is passed through the murmur3
+// finalizer, since hashes like FNV spread short, similar inputs poorly across
+// the high bits that rendezvous scoring depends on.
+type Seeded struct {
+	seed   [8]byte
+	hasher Hasher
+	// state is the wrapped hash's state after the seed, for FNV and CRC64,
+	// which can carry on from it over the data without copying it.
+	state uint64
+}
+
+func NewSeeded(seed int64, hasher Hasher) *Seeded {
+	s := &Seeded{hasher: hasher}
+	binary.LittleEndian.PutUint64(s.seed[:], uint64(seed))
+	switch hasher.(type) {
+	case FNV:
+		s.state = fnvUpdate(fnvOffset, s.seed[:])
+	case CRC64:
+		s.state = crc64.Update(0, crc64Table, s.seed[:])
+	}
+	return s
+}
+
+// seededBufs holds buffers for joining the seed and data for hashers that
+// can't carry on from a state, so that hashing doesn't allocate.
+var seededBufs = sync.Pool{New: func() interface{} { return new([]byte) }}
+
+func (h *Seeded) Hash(data []byte) uint64 {
+	switch h.hasher.(type) {
+	case FNV:
+		return fmix64(fnvUpdate(h.state, data))
+	case CRC64:
+		return fmix64(crc64.Update(h.state, crc64Table, data))
+	}
+	buf := seededBufs.Get().(*[]byte)
+	*buf = append(append((*buf)[:0], h.seed[:]...), data...)
+	sum := h.hasher.Hash(*buf)
+	seededBufs.Put(buf)
+	return fmix64(sum)
+}
+
+// fmix64 is the murmur3 64-bit finalizer.
+func fmix64(k uint64) uint64 {
+	k ^= k >> 33
+	k *= 0xff51afd7ed558ccd
+	k ^= k >> 33
+	k *= 0xc4ceb9fe1a85ec53
+	k ^= k >> 33
+	return k
+}
@@ -0,0 +1,55 @@
+package hashing
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"hash/maphash"
+	"strconv"
+	"testing"
+)
+
+func TestFNVMatchesHashFNV(t *testing.T) {
+	for _, data := range []string{"", "a", "1-key", "hello, world"} {
+		h := fnv.New64a()
+		h.Write([]byte(data))
+		if got, want := (FNV{}).Hash([]byte(data)), h.Sum64(); got != want {
+			t.Errorf("FNV.Hash(%q) = %x, want %x", data, got, want)
+		}
+	}
+}
+
+// TestSeededHashesSeedThenData checks Seeded hashes the same as hashing the
+// seed and data joined, whether it carries on from the seed's state or joins
+// them itself.
+func TestSeededHashesSeedThenData(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		hasher Hasher
+	}{
+		{"fnv", FNV{}},
+		{"crc64", CRC64{}},
+		{"maphash", NewMapHash(maphash.MakeSeed())},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			seed := int64(-42)
+			seeded := NewSeeded(seed, tt.hasher)
+			for i := 0; i < 100; i++ {
+				data := []byte(strconv.Itoa(i) + "-key")
+				joined := append(binary.LittleEndian.AppendUint64(nil, uint64(seed)), data...)
+				if got, want := seeded.Hash(data), fmix64(tt.hasher.Hash(joined)); got != want {
+					t.Fatalf("Hash(%q) = %x, want %x", data, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSeededHashDoesNotAllocate(t *testing.T) {
+	data := []byte("12-some-key")
+	for _, hasher := range []Hasher{FNV{}, CRC64{}, NewMapHash(maphash.MakeSeed())} {
+		seeded := NewSeeded(1, hasher)
+		if allocs := testing.AllocsPerRun(100, func() { seeded.Hash(data) }); allocs != 0 {
+			t.Errorf("Seeded(%T).Hash made %v allocations, want 0", hasher, allocs)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package hashing
+
+// Rebalance moves each of keys onto its current top rf sites, as placement
+// would put it now that capacities or membership have changed. See
+// RebalancePlacement.
+func (r *Ring) Rebalance(keys []string, rf int) (moved int) {
+	return RebalancePlacement(r.sites, r.TopSites, keys, rf)
+}
+
+// RebalancePlacement drops each of keys from the sites that are no longer
+// among those top returns for it, then writes it to those of them that don't
+// hold it yet and have room. Keys whose top sites haven't changed aren't
+// touched; a key whose new sites are full is left with fewer copies. It
+// returns the number of key copies written, the cost of the migration. It
+// lets placement algorithms outside this package share Ring.Rebalance.
+func RebalancePlacement(sites []*Site, top func(key string, n int) []*Site, keys []string, rf int) (moved int) {
+	want := make(map[string][]*Site, len(keys))
+	for _, key := range keys {
+		want[key] = top(key, rf)
+	}
+	// Drop every stale copy before writing any new ones, so the room they
+	// free is available.
+	for _, key := range keys {
+		for _, s := range sites {
+			if s.Has(key) && !containsSite(want[key], s) {
+				s.drop(key)
+			}
+		}
+	}
+	for _, key := range keys {
+		for _, s := range want[key] {
+			if !s.Has(key) && !s.Full() {
+				s.HandleWrite(key)
+				moved++
+			}
+		}
+	}
+	return moved
+}
+
+func containsSite(sites []*Site, s *Site) bool {
+	for _, o := range sites {
+		if o == s {
+			return true
+		}
+	}
+	return false
+}
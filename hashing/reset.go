@@ -0,0 +1,15 @@
+package hashing
+
+// Reset empties every site on the ring, as ResetSites does, so the ring can
+// be reused for another run.
+func (r *Ring) Reset() {
+	ResetSites(r.sites)
+}
+
+// ResetSites resets each of sites. It lets placement algorithms outside this
+// package share Ring.Reset.
+func ResetSites(sites []*Site) {
+	for _, s := range sites {
+		s.Reset()
+	}
+}
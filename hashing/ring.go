@@ -0,0 +1,437 @@
+// Package hashing implements weighted rendezvous hashing.
+//
+// https://en.wikipedia.org/wiki/Rendezvous_hashing
+// https://randorithms.com/2020/12/26/rendezvous-hashing.html
+// https://www.snia.org/sites/default/files/SDC15_presentations/dist_sys/Jason_Resch_New_Consistent_Hashings_Rev.pdf
+package hashing
+
+import (
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// Ring is a set of sites that keys are placed on. Rings with equivalent
+// hashers and the same sites place keys identically. Sites without capacity
+// are treated as decommissioned and never placed on.
+type Ring struct {
+	sites      []*Site
+	hasher     Hasher
+	unweighted bool
+	vnodes     int
+	scoreFunc  ScoreFunc
+	inverted   bool
+	byFree     bool
+}
+
+// ScoreFunc weights a site's hash for a key, in (0, 1), by its capacity. The
+// site with the highest score is preferred.
+type ScoreFunc func(capacity int, hash float64) float64
+
+// ClassicScore is the default ScoreFunc, -capacity / ln(hash), under which a
+// site's share of keys is proportional to its capacity.
+func ClassicScore(capacity int, hash float64) float64 {
+	return -1 * float64(capacity) / math.Log(hash)
+}
+
+// LogWeightScore is a ScoreFunc that weights by ln(1 + capacity) in place of
+// capacity, so larger sites get more keys, but less than proportionally more.
+func LogWeightScore(capacity int, hash float64) float64 {
+	return -1 * math.Log1p(float64(capacity)) / math.Log(hash)
+}
+
+// NewRing returns a ring that weights sites by capacity with ClassicScore.
+func NewRing(hasher Hasher, sites []*Site) *Ring {
+	return &Ring{sites: sites, hasher: hasher, scoreFunc: ClassicScore}
+}
+
+// SetScoreFunc changes how sites' hashes are weighted by capacity. It has no
+// effect on unweighted rings.
+func (r *Ring) SetScoreFunc(f ScoreFunc) {
+	r.scoreFunc = f
+}
+
+// SetInverted reverses the ring's preference order, so that the least
+// preferred site comes first, as a check that code walking the order doesn't
+// depend on its direction. Normally sites are ordered by descending score.
+func (r *Ring) SetInverted(inverted bool) {
+	r.inverted = inverted
+}
+
+// SetWeightByFree makes the ring weight sites by their free capacity, what's
+// left of their capacity after the keys they store, rather than their total
+// capacity, so that keys drift towards emptier sites as the ring fills. Full
+// sites score 0 and are least preferred. Placement then depends on what's
+// stored, so a key needn't place where it was written, and sites mustn't be
+// written to while the ring is placing keys. It has no effect on unweighted
+// rings.
+func (r *Ring) SetWeightByFree(byFree bool) {
+	r.byFree = byFree
+}
+
+// SetWeighted controls whether sites are weighted by capacity. Unweighted
+// rings order sites purely by hash value, as in classic HRW.
+func (r *Ring) SetWeighted(weighted bool) {
+	r.unweighted = !weighted
+}
+
+// SetVNodes makes each site take part in scoring as n virtual nodes, scoring
+// as its best virtual node. Ordering is still of physical sites. n <= 1
+// disables virtual nodes.
+func (r *Ring) SetVNodes(n int) {
+	r.vnodes = n
+}
+
+func (r *Ring) Sites() []*Site {
+	return r.sites
+}
+
+// AddSite adds s to the ring.
+func (r *Ring) AddSite(s *Site) {
+	r.sites = append(r.sites, s)
+}
+
+// RemoveSite removes the site with the given id from the ring and returns it,
+// or nil if the ring has no such site. Slices previously returned by Sites are
+// left untouched.
+func (r *Ring) RemoveSite(id int) *Site {
+	for i, s := range r.sites {
+		if s.id == id {
+			r.sites = append(r.sites[:i:i], r.sites[i+1:]...)
+			return s
+		}
+	}
+	return nil
+}
+
+// RemapFraction returns the fraction of keys whose primary site differs
+// between the before and after memberships.
+func (r *Ring) RemapFraction(keys []string, before, after []*Site) float64 {
+	if len(keys) == 0 {
+		return 0
+	}
+	var moved int
+	for _, key := range keys {
+		b, a := r.orderSites(before, key), r.orderSites(after, key)
+		if len(b) == 0 || len(a) == 0 || b[0] != a[0] {
+			moved++
+		}
+	}
+	return float64(moved) / float64(len(keys))
+}
+
+// OrderedSites returns the ring's sites ordered by preference for key, most
+// preferred first.
+func (r *Ring) OrderedSites(key string) []*Site {
+	return r.orderSites(r.sites, key)
+}
+
+// ScoredSite is a site and its rendezvous score for a key.
+type ScoredSite struct {
+	Site  *Site
+	Score float64
+}
+
+// better reports whether s is preferred over o: it has a higher score or, so
+// that ties resolve the same way every run, an equal score and a lower id.
+func (s ScoredSite) better(o ScoredSite) bool {
+	if s.Score != o.Score {
+		return s.Score > o.Score
+	}
+	return s.Site.id < o.Site.id
+}
+
+// prefers reports whether the ring prefers s over o: whether s is better, or
+// on an inverted ring, worse.
+func (r *Ring) prefers(s, o ScoredSite) bool {
+	if r.inverted {
+		return o.better(s)
+	}
+	return s.better(o)
+}
+
+// ScoredSites is like OrderedSites but includes each site's score.
+func (r *Ring) ScoredSites(key string) []ScoredSite {
+	return r.scoreSites(r.sites, key)
+}
+
+// orderScratch is scratch space for orderSites: scores holds each site's
+// score by its index in the sites being ordered, and order the indexes of
+// those with capacity, to be sorted. Keeping scores flat rather than in
+// per-site structs keeps sorting from chasing pointers.
+type orderScratch struct {
+	scores  []float64
+	order   []int
+	hashKey []byte
+}
+
+// orderScratchPool reuses orderScratches across calls, giving concurrent
+// callers their own.
+var orderScratchPool = sync.Pool{New: func() interface{} { return new(orderScratch) }}
+
+func (r *Ring) orderSites(sites []*Site, key string) []*Site {
+	sc := orderScratchPool.Get().(*orderScratch)
+	defer orderScratchPool.Put(sc)
+	sc.scores = slices.Grow(sc.scores[:0], len(sites))[:len(sites)]
+	sc.order = sc.order[:0]
+	for i, s := range sites {
+		if s.capacity <= 0 {
+			continue
+		}
+		sc.scores[i], sc.hashKey = r.siteScore(s, key, sc.hashKey)
+		sc.order = append(sc.order, i)
+	}
+	// Sort as ScoredSite.better does: highest score first, then lowest id,
+	// or the reverse on an inverted ring.
+	dir := 1
+	if r.inverted {
+		dir = -1
+	}
+	slices.SortFunc(sc.order, func(a, b int) int {
+		switch {
+		case sc.scores[a] > sc.scores[b]:
+			return -dir
+		case sc.scores[a] < sc.scores[b]:
+			return dir
+		}
+		return dir * (sites[a].id - sites[b].id)
+	})
+	if len(sc.order) == 0 {
+		return nil
+	}
+	ordered := make([]*Site, len(sc.order))
+	for i, j := range sc.order {
+		ordered[i] = sites[j]
+	}
+	return ordered
+}
+
+func (r *Ring) scoreSites(sites []*Site, key string) []ScoredSite {
+	var scored []ScoredSite
+	hashKey := make([]byte, 0, 64)
+	for _, s := range sites {
+		if s.capacity <= 0 {
+			continue
+		}
+		var checksum float64
+		checksum, hashKey = r.siteScore(s, key, hashKey)
+		scored = append(scored, ScoredSite{Site: s, Score: checksum})
+	}
+	sort.Slice(scored, func(i, j int) bool {
+		return r.prefers(scored[i], scored[j])
+	})
+	return scored
+}
+
+// Collisions returns how many pairs of sites have identical scores for key.
+// Since ties are broken by site id, collisions bias placement towards lower
+// ids, and any at all suggest the keys are stressing the hasher.
+func (r *Ring) Collisions(key string) int {
+	var pairs int
+	scored := r.scoreSites(r.sites, key)
+	for i := 0; i < len(scored); {
+		j := i + 1
+		for j < len(scored) && scored[j].Score == scored[i].Score {
+			j++
+		}
+		pairs += (j - i) * (j - i - 1) / 2
+		i = j
+	}
+	return pairs
+}
+
+// TopSites returns the n most preferred sites for key, most preferred first.
+// It's equivalent to OrderedSites(key)[:n], but keeps only n sites in a heap
+// rather than sorting all of them.
+func (r *Ring) TopSites(key string, n int) []*Site {
+	if n > len(r.sites) {
+		n = len(r.sites)
+	}
+	if n <= 0 {
+		return nil
+	}
+	h, _ := r.topScored(key, n, make(scoredHeap, 0, n), make([]byte, 0, 64))
+	top := make([]*Site, len(h))
+	for i, s := range h {
+		top[i] = s.Site
+	}
+	return top
+}
+
+// PlaceBatch returns the top rf sites for each of keys, as TopSites would. It
+// reuses its scratch space across keys, so is cheaper than calling TopSites
+// for each. Sites aren't written to.
+func (r *Ring) PlaceBatch(keys []string, rf int) map[string][]*Site {
+	if rf > len(r.sites) {
+		rf = len(r.sites)
+	}
+	placed := make(map[string][]*Site, len(keys))
+	if rf <= 0 {
+		return placed
+	}
+	h := make(scoredHeap, 0, rf)
+	hashKey := make([]byte, 0, 64)
+	// All keys' sites share one backing array.
+	all := make([]*Site, 0, len(keys)*rf)
+	for _, key := range keys {
+		h, hashKey = r.topScored(key, rf, h[:0], hashKey)
+		start := len(all)
+		for _, s := range h {
+			all = append(all, s.Site)
+		}
+		placed[key] = all[start:len(all):len(all)]
+	}
+	return placed
+}
+
+// Placement is where a key is placed, by site id. Unlike the sites returned by
+// TopSites, it shares nothing with the ring, so is safe to keep and modify.
+type Placement struct {
+	// Primary is the id of the most preferred site, or -1 if there are no
+	// sites with capacity.
+	Primary int
+	// Replicas are the ids of the top sites, most preferred first, starting
+	// with Primary.
+	Replicas []int
+	// Scores are the rendezvous scores of Replicas, in the same order.
+	Scores []float64
+}
+
+// Place returns the placement of key on the top rf sites, as TopSites would
+// order them.
+func (r *Ring) Place(key string, rf int) Placement {
+	p := Placement{Primary: -1}
+	rf = min(rf, len(r.sites))
+	if rf <= 0 {
+		return p
+	}
+	h, _ := r.topScored(key, rf, make(scoredHeap, 0, rf), make([]byte, 0, 64))
+	p.Replicas = make([]int, len(h))
+	p.Scores = make([]float64, len(h))
+	for i, s := range h {
+		p.Replicas[i] = s.Site.id
+		p.Scores[i] = s.Score
+	}
+	if len(h) > 0 {
+		p.Primary = p.Replicas[0]
+	}
+	return p
+}
+
+// topScored returns the n best scoring sites for key, best first. h and
+// hashKey are scratch space, returned for reuse.
+func (r *Ring) topScored(key string, n int, h scoredHeap, hashKey []byte) (scoredHeap, []byte) {
+	for _, s := range r.sites {
+		if s.capacity <= 0 {
+			continue
+		}
+		var num float64
+		num, hashKey = r.siteScore(s, key, hashKey)
+		ss := ScoredSite{Site: s, Score: num}
+		if len(h) < n {
+			h = append(h, ss)
+			h.up(len(h)-1, r.inverted)
+		} else if r.prefers(ss, h[0]) {
+			h[0] = ss
+			h.down(0, len(h), r.inverted)
+		}
+	}
+	// Heap sort: repeatedly move the worst remaining site to the end.
+	for end := len(h) - 1; end > 0; end-- {
+		h[0], h[end] = h[end], h[0]
+		h.down(0, end, r.inverted)
+	}
+	return h, hashKey
+}
+
+// scoredHeap is a min-heap of sites by preference, the least preferred at the
+// root. It's hand rolled rather than using container/heap so that pushing and
+// popping don't allocate. Its methods take whether the ring is inverted, in
+// which case the best scoring site is least preferred, so at the root.
+type scoredHeap []ScoredSite
+
+func (h scoredHeap) less(i, j int, inverted bool) bool {
+	if inverted {
+		return h[i].better(h[j])
+	}
+	return h[j].better(h[i])
+}
+
+func (h scoredHeap) up(j int, inverted bool) {
+	for j > 0 {
+		i := (j - 1) / 2
+		if !h.less(j, i, inverted) {
+			break
+		}
+		h[i], h[j] = h[j], h[i]
+		j = i
+	}
+}
+
+// down sifts h[i] down within h[:n].
+func (h scoredHeap) down(i, n int, inverted bool) {
+	for {
+		j := 2*i + 1
+		if j >= n {
+			break
+		}
+		if r := j + 1; r < n && h.less(r, j, inverted) {
+			j = r
+		}
+		if !h.less(j, i, inverted) {
+			break
+		}
+		h[i], h[j] = h[j], h[i]
+		i = j
+	}
+}
+
+// siteScore returns the rendezvous score of s for key, which is the best score
+// of its virtual nodes if the ring has them. hashKey is scratch space for the
+// hash input and is returned for reuse.
+func (r *Ring) siteScore(s *Site, key string, hashKey []byte) (float64, []byte) {
+	if r.vnodes <= 1 {
+		hashKey = appendHashKey(hashKey[:0], s.id, -1, key)
+		return r.score(s, hashKey), hashKey
+	}
+	best := math.Inf(-1)
+	for v := 0; v < r.vnodes; v++ {
+		hashKey = appendHashKey(hashKey[:0], s.id, v, key)
+		best = math.Max(best, r.score(s, hashKey))
+	}
+	return best, hashKey
+}
+
+// appendHashKey appends the hash input for site id and key to b, prefixing
+// key with the virtual node index if vnode >= 0. It produces the same bytes as
+// fmt.Sprintf("%d-%s") or "%d-%d-%s" without their per-call allocations.
+func appendHashKey(b []byte, id, vnode int, key string) []byte {
+	b = strconv.AppendInt(b, int64(id), 10)
+	b = append(b, '-')
+	if vnode >= 0 {
+		b = strconv.AppendInt(b, int64(vnode), 10)
+		b = append(b, '-')
+	}
+	return append(b, key...)
+}
+
+// score returns the rendezvous score of s for hashKey.
+func (r *Ring) score(s *Site, hashKey []byte) float64 {
+	c := float64(r.hasher.Hash(hashKey)) / float64(math.MaxUint64)
+	// Keep c inside (0, 1): hashes at or near the max round to 1, whose log is
+	// 0 and would make the score infinite.
+	if c >= 1 {
+		c = math.Nextafter(1, 0)
+	} else if c <= 0 {
+		c = math.SmallestNonzeroFloat64
+	}
+	if r.unweighted {
+		return c
+	}
+	if r.byFree {
+		return r.scoreFunc(max(s.capacity-len(s.knownKeys), 0), c)
+	}
+	return r.scoreFunc(s.capacity, c)
+}
@@ -0,0 +1,438 @@
+package hashing
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+// newTestSites returns sites with ids counting up from 1 and the given
+// capacities.
+func newTestSites(caps ...int) []*Site {
+	sites := make([]*Site, len(caps))
+	for i, c := range caps {
+		sites[i] = NewSite(i+1, c)
+	}
+	return sites
+}
+
+// siteIDs returns the ids of sites, in order.
+func siteIDs(sites []*Site) []int {
+	ids := make([]int, len(sites))
+	for i, s := range sites {
+		ids[i] = s.ID()
+	}
+	return ids
+}
+
+func TestSameSeedSameOrder(t *testing.T) {
+	a := NewRing(NewSeeded(42, FNV{}), newTestSites(100, 200, 300, 400))
+	b := NewRing(NewSeeded(42, FNV{}), newTestSites(100, 200, 300, 400))
+	other := NewRing(NewSeeded(43, FNV{}), newTestSites(100, 200, 300, 400))
+	var differ bool
+	for i := 0; i < 1000; i++ {
+		key := strconv.Itoa(i)
+		want := siteIDs(a.OrderedSites(key))
+		if got := siteIDs(b.OrderedSites(key)); !slices.Equal(got, want) {
+			t.Fatalf("key %s: rings with the same seed ordered sites %v and %v", key, want, got)
+		}
+		if !slices.Equal(siteIDs(other.OrderedSites(key)), want) {
+			differ = true
+		}
+	}
+	if !differ {
+		t.Error("rings with different seeds ordered every key identically")
+	}
+}
+
+// TestScoreFuncsOrderStably checks each provided ScoreFunc orders sites the
+// same way every time for a fixed seed, and the same as when this test was
+// written, so a change to either formula shows up here.
+func TestScoreFuncsOrderStably(t *testing.T) {
+	tests := []struct {
+		name  string
+		score ScoreFunc
+		want  map[string][]int
+	}{
+		{"classic", ClassicScore, map[string][]int{"a": {1, 4, 3, 2}, "b": {3, 4, 2, 1}, "c": {2, 3, 1, 4}}},
+		{"logweight", LogWeightScore, map[string][]int{"a": {1, 3, 4, 2}, "b": {3, 2, 4, 1}, "c": {2, 1, 3, 4}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := NewRing(NewSeeded(42, FNV{}), newTestSites(100, 200, 300, 400))
+			a.SetScoreFunc(tt.score)
+			b := NewRing(NewSeeded(42, FNV{}), newTestSites(100, 200, 300, 400))
+			b.SetScoreFunc(tt.score)
+			for key, want := range tt.want {
+				if got := siteIDs(a.OrderedSites(key)); !slices.Equal(got, want) {
+					t.Errorf("key %s: ordered sites %v, want %v", key, got, want)
+				}
+			}
+			for i := 0; i < 1000; i++ {
+				key := strconv.Itoa(i)
+				if x, y := siteIDs(a.OrderedSites(key)), siteIDs(b.OrderedSites(key)); !slices.Equal(x, y) {
+					t.Fatalf("key %s: rings with the same seed ordered sites %v and %v", key, x, y)
+				}
+			}
+		})
+	}
+}
+
+// primaryCounts returns how many of n sequential keys each of ring's sites is
+// the primary for, by id.
+func primaryCounts(ring *Ring, n int) map[int]int {
+	return primaryCountsOf(ring, n, strconv.Itoa)
+}
+
+func TestWeightedMatchesUnweightedForEqualCapacities(t *testing.T) {
+	const keys = 20000
+	weighted := NewRing(NewSeeded(1, FNV{}), newTestSites(100, 100, 100, 100))
+	unweighted := NewRing(NewSeeded(1, FNV{}), newTestSites(100, 100, 100, 100))
+	unweighted.SetWeighted(false)
+	w, u := primaryCounts(weighted, keys), primaryCounts(unweighted, keys)
+	// Each site's count is binomial with p = 1/4 under either scorer, so
+	// should be within 4 standard deviations of an even share.
+	p := 0.25
+	want, sigma := keys*p, math.Sqrt(keys*p*(1-p))
+	for id := 1; id <= 4; id++ {
+		if math.Abs(float64(w[id])-want) > 4*sigma || math.Abs(float64(u[id])-want) > 4*sigma {
+			t.Errorf("site %d: weighted placed %d keys and unweighted %d, want both within 4 sigma (%.0f) of %.0f", id, w[id], u[id], sigma, want)
+		}
+	}
+}
+
+// keyKinds are the kinds of key placement should spread evenly: small
+// sequential integers, and sparse 64-bit ids like --sparseKeys writes, which
+// scatters k with the same murmur3 finalizer as fmix64.
+var keyKinds = []struct {
+	name string
+	key  func(i int) string
+}{
+	{"sequential", strconv.Itoa},
+	{"sparse", func(i int) string { return strconv.FormatUint(fmix64(uint64(i)), 10) }},
+}
+
+// primaryCountsOf returns how many of n keys, named by key, each of ring's
+// sites is the primary for, by id.
+func primaryCountsOf(ring *Ring, n int, key func(i int) string) map[int]int {
+	counts := make(map[int]int)
+	for i := 0; i < n; i++ {
+		counts[ring.TopSites(key(i), 1)[0].ID()]++
+	}
+	return counts
+}
+
+// TestEqualCapacitiesShareUniformly checks that, for sites of equal capacity,
+// each site's share of primary placements is within 3 standard deviations of
+// an even share, for sequential and sparse keys alike.
+func TestEqualCapacitiesShareUniformly(t *testing.T) {
+	const keys = 50000
+	tests := []struct {
+		name   string
+		sites  int
+		hasher Hasher
+	}{
+		{"2 sites fnv", 2, FNV{}},
+		{"5 sites fnv", 5, FNV{}},
+		{"20 sites fnv", 20, FNV{}},
+		{"5 sites crc64", 5, CRC64{}},
+	}
+	for _, tt := range tests {
+		for _, kind := range keyKinds {
+			t.Run(tt.name+" "+kind.name, func(t *testing.T) {
+				caps := make([]int, tt.sites)
+				for i := range caps {
+					caps[i] = 100
+				}
+				counts := primaryCountsOf(NewRing(NewSeeded(1, tt.hasher), newTestSites(caps...)), keys, kind.key)
+				// Each site's count is binomial with p = 1/sites.
+				p := 1 / float64(tt.sites)
+				want, sigma := keys*p, math.Sqrt(keys*p*(1-p))
+				for id := 1; id <= tt.sites; id++ {
+					if got := float64(counts[id]); math.Abs(got-want) > 3*sigma {
+						t.Errorf("site %d placed %.0f keys, want within 3 sigma (%.0f) of %.0f", id, got, sigma, want)
+					}
+				}
+			})
+		}
+	}
+}
+
+// TestCapacityRatioGivesKeyRatio checks that under the weighted scorer a site
+// with twice another's capacity is primary for roughly twice as many keys,
+// for sequential and sparse keys alike.
+func TestCapacityRatioGivesKeyRatio(t *testing.T) {
+	const keys = 60000
+	for _, kind := range keyKinds {
+		t.Run(kind.name, func(t *testing.T) {
+			counts := primaryCountsOf(NewRing(NewSeeded(1, FNV{}), newTestSites(200, 100)), keys, kind.key)
+			if ratio := float64(counts[1]) / float64(counts[2]); math.Abs(ratio-2) > 0.1 {
+				t.Errorf("sites with capacities 200 and 100 placed %d and %d keys, a ratio of %.3f, want about 2", counts[1], counts[2], ratio)
+			}
+		})
+	}
+}
+
+// benchCaps returns n capacities cycling through 100 to 500.
+func benchCaps(n int) []int {
+	caps := make([]int, n)
+	for i := range caps {
+		caps[i] = 100 * (i%5 + 1)
+	}
+	return caps
+}
+
+// benchRing returns a ring of n sites with benchCaps capacities, so that
+// placement is weighted.
+func benchRing(n int) *Ring {
+	return NewRing(NewSeeded(1, FNV{}), newTestSites(benchCaps(n)...))
+}
+
+// benchKeys are the keys benchmarks place, cycled through.
+var benchKeys = func() []string {
+	keys := make([]string, 1024)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+	}
+	return keys
+}()
+
+func BenchmarkHashOrderedSites(b *testing.B) {
+	for _, n := range []int{10, 100, 1000, 10000} {
+		b.Run(strconv.Itoa(n)+"sites", func(b *testing.B) {
+			ring := benchRing(n)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				ring.OrderedSites(benchKeys[i%len(benchKeys)])
+			}
+		})
+	}
+}
+
+// BenchmarkOrderedVsScoredSites compares ordering sites by sorting indexes
+// over flat scores, as OrderedSites does, against sorting ScoredSites, as it
+// used to.
+func BenchmarkOrderedVsScoredSites(b *testing.B) {
+	ring := benchRing(10000)
+	b.Run("flat", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			ring.OrderedSites(benchKeys[i%len(benchKeys)])
+		}
+	})
+	b.Run("scored", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			ring.ScoredSites(benchKeys[i%len(benchKeys)])
+		}
+	})
+}
+
+func BenchmarkPlaceBatch(b *testing.B) {
+	const rf = 3
+	ring := benchRing(100)
+	b.Run("batch", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			ring.PlaceBatch(benchKeys, rf)
+		}
+	})
+	b.Run("loop", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			placed := make(map[string][]*Site, len(benchKeys))
+			for _, key := range benchKeys {
+				placed[key] = ring.TopSites(key, rf)
+			}
+		}
+	})
+}
+
+func TestHashKeyMatchesSprintf(t *testing.T) {
+	for _, tt := range []struct {
+		id, vnode int
+		key       string
+		want      string
+	}{
+		{1, -1, "key", fmt.Sprintf("%d-%s", 1, "key")},
+		{-7, -1, "", fmt.Sprintf("%d-%s", -7, "")},
+		{123456, 0, "k-1", fmt.Sprintf("%d-%d-%s", 123456, 0, "k-1")},
+		{2, 99, "ключ", fmt.Sprintf("%d-%d-%s", 2, 99, "ключ")},
+	} {
+		if got := string(appendHashKey(nil, tt.id, tt.vnode, tt.key)); got != tt.want {
+			t.Errorf("appendHashKey(%d, %d, %q) = %q, want %q", tt.id, tt.vnode, tt.key, got, tt.want)
+		}
+	}
+}
+
+// TestPlacementMatchesSprintfHashing checks that orderings are unchanged from
+// when the hash input was built with fmt.Sprintf, for plain and virtual node
+// rings.
+func TestPlacementMatchesSprintfHashing(t *testing.T) {
+	for _, vnodes := range []int{1, 4} {
+		ring := NewRing(NewSeeded(1, FNV{}), newTestSites(100, 200, 300, 400, 500))
+		ring.SetVNodes(vnodes)
+		for i := 0; i < 500; i++ {
+			key := strconv.Itoa(i)
+			var scored []ScoredSite
+			for _, s := range ring.Sites() {
+				best := math.Inf(-1)
+				for v := 0; v < vnodes; v++ {
+					input := fmt.Sprintf("%d-%s", s.ID(), key)
+					if vnodes > 1 {
+						input = fmt.Sprintf("%d-%d-%s", s.ID(), v, key)
+					}
+					best = math.Max(best, ring.score(s, []byte(input)))
+				}
+				scored = append(scored, ScoredSite{Site: s, Score: best})
+			}
+			sort.Slice(scored, func(i, j int) bool { return scored[i].better(scored[j]) })
+			var want []int
+			for _, s := range scored {
+				want = append(want, s.Site.ID())
+			}
+			if got := siteIDs(ring.OrderedSites(key)); !slices.Equal(got, want) {
+				t.Fatalf("vnodes %d, key %s: ordered %v, want %v as with fmt.Sprintf", vnodes, key, got, want)
+			}
+		}
+	}
+}
+
+func BenchmarkHashKey(b *testing.B) {
+	b.Run("sprintf", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = []byte(fmt.Sprintf("%d-%s", i%1000, benchKeys[i%len(benchKeys)]))
+		}
+	})
+	b.Run("append", func(b *testing.B) {
+		b.ReportAllocs()
+		buf := make([]byte, 0, 64)
+		for i := 0; i < b.N; i++ {
+			buf = appendHashKey(buf[:0], i%1000, -1, benchKeys[i%len(benchKeys)])
+		}
+	})
+	for name, hasher := range map[string]Hasher{"fnv": FNV{}, "crc64": CRC64{}} {
+		b.Run("append+seeded "+name, func(b *testing.B) {
+			seeded := NewSeeded(1, hasher)
+			b.ReportAllocs()
+			buf := make([]byte, 0, 64)
+			for i := 0; i < b.N; i++ {
+				buf = appendHashKey(buf[:0], i%1000, -1, benchKeys[i%len(benchKeys)])
+				seeded.Hash(buf)
+			}
+		})
+	}
+}
+
+func TestTopSitesMatchesOrderedSites(t *testing.T) {
+	tests := []struct {
+		name     string
+		caps     []int
+		vnodes   int
+		inverted bool
+	}{
+		{"weighted", []int{100, 200, 300, 400, 500}, 1, false},
+		{"decommissioned site", []int{100, 0, 300, 400, 500}, 1, false},
+		{"vnodes", []int{100, 200, 300, 400, 500}, 3, false},
+		{"inverted", []int{100, 200, 300, 400, 500}, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ring := NewRing(NewSeeded(1, FNV{}), newTestSites(tt.caps...))
+			ring.SetVNodes(tt.vnodes)
+			ring.SetInverted(tt.inverted)
+			for i := 0; i < 500; i++ {
+				key := strconv.Itoa(i)
+				ordered := siteIDs(ring.OrderedSites(key))
+				for rf := 1; rf <= len(tt.caps); rf++ {
+					want := ordered[:min(rf, len(ordered))]
+					if got := siteIDs(ring.TopSites(key, rf)); !slices.Equal(got, want) {
+						t.Fatalf("key %s: TopSites(%d) = %v, want OrderedSites()[:%d] = %v", key, rf, got, rf, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+// TestOrderedSitesMatchesScoredSites checks OrderedSites, which sorts indexes
+// over flat scores, orders sites as sorting ScoredSites does, which is how
+// OrderedSites used to work.
+func TestOrderedSitesMatchesScoredSites(t *testing.T) {
+	tests := []struct {
+		name     string
+		hasher   Hasher
+		caps     []int
+		vnodes   int
+		inverted bool
+	}{
+		{"weighted", NewSeeded(1, FNV{}), []int{100, 200, 300, 400, 500}, 1, false},
+		{"decommissioned site", NewSeeded(1, FNV{}), []int{100, 0, 300, 400, 500}, 1, false},
+		{"vnodes", NewSeeded(1, FNV{}), []int{100, 200, 300, 400, 500}, 3, false},
+		{"inverted", NewSeeded(1, FNV{}), []int{100, 200, 300, 400, 500}, 1, true},
+		{"ties", constHasher(1 << 63), []int{100, 100, 100, 100, 100}, 1, false},
+		{"many sites", NewSeeded(1, FNV{}), benchCaps(1000), 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ring := NewRing(tt.hasher, newTestSites(tt.caps...))
+			ring.SetVNodes(tt.vnodes)
+			ring.SetInverted(tt.inverted)
+			for i := 0; i < 200; i++ {
+				key := strconv.Itoa(i)
+				var want []int
+				for _, s := range ring.ScoredSites(key) {
+					want = append(want, s.Site.ID())
+				}
+				if got := siteIDs(ring.OrderedSites(key)); !slices.Equal(got, want) {
+					t.Fatalf("key %s: OrderedSites() = %v, want %v", key, got, want)
+				}
+			}
+		})
+	}
+}
+
+// constHasher hashes everything to the same value.
+type constHasher uint64
+
+func (h constHasher) Hash([]byte) uint64 { return uint64(h) }
+
+func TestExtremeHashesScoreFinitely(t *testing.T) {
+	for _, h := range []constHasher{math.MaxUint64, math.MaxUint64 - 1, 0} {
+		ring := NewRing(h, newTestSites(100, 300, 200, 300))
+		for _, s := range ring.ScoredSites("key") {
+			if math.IsInf(s.Score, 0) || math.IsNaN(s.Score) {
+				t.Errorf("hash %#x: site %d scored %v", uint64(h), s.Site.ID(), s.Score)
+			}
+		}
+		// Every site hashes alike, so larger capacities win and ties go
+		// to the lower id, on every call.
+		want := []int{2, 4, 3, 1}
+		for i := 0; i < 3; i++ {
+			if got := siteIDs(ring.OrderedSites("key")); !slices.Equal(got, want) {
+				t.Fatalf("hash %#x: ordered %v, want %v", uint64(h), got, want)
+			}
+		}
+	}
+}
+
+func TestTiesGoToLowerID(t *testing.T) {
+	// With one hash for every input and equal capacities, every site ties.
+	ring := NewRing(constHasher(1<<63), []*Site{NewSite(3, 100), NewSite(1, 100), NewSite(2, 100)})
+	for i := 0; i < 10; i++ {
+		key := strconv.Itoa(i)
+		if got := siteIDs(ring.OrderedSites(key)); !slices.Equal(got, []int{1, 2, 3}) {
+			t.Errorf("key %s: OrderedSites() = %v, want [1 2 3]", key, got)
+		}
+		if got := siteIDs(ring.TopSites(key, 2)); !slices.Equal(got, []int{1, 2}) {
+			t.Errorf("key %s: TopSites(2) = %v, want [1 2]", key, got)
+		}
+		if got := ring.Place(key, 1).Primary; got != 1 {
+			t.Errorf("key %s: Place(1).Primary = %d, want 1", key, got)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package hashing
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// Site is a storage node that keys are placed on.
+type Site struct {
+	id        int
+	capacity  int
+	knownKeys map[string]struct{}
+	evictions int
+	online    bool
+
+	// zone is the failure domain the site is in, if any.
+	zone string
+
+	// readOnly sites serve reads but take no writes, and writeOnly sites
+	// take writes but serve no reads.
+	readOnly  bool
+	writeOnly bool
+
+	// name is the site's name for output, if any. Placement always hashes
+	// the id, so naming a site doesn't move keys.
+	name string
+
+	// readHits and readMisses are atomic so that HandleRead can be called
+	// from several goroutines at once.
+	readHits   atomic.Int64
+	readMisses atomic.Int64
+
+	// uncounted is set while reads aren't counted in readHits and
+	// readMisses.
+	uncounted bool
+
+	// keyReads counts read hits per key once TrackKeyReads is called,
+	// guarded by keyReadsMu.
+	keyReads   map[string]int
+	keyReadsMu sync.Mutex
+
+	// order holds knownKeys oldest first, for eviction.
+	order []string
+}
+
+// NewSite returns a site with the given id able to hold capacity keys. Sites
+// on a ring must have distinct ids, since placement hashes them.
+func NewSite(id, capacity int) *Site {
+	return &Site{id: id, capacity: capacity, knownKeys: make(map[string]struct{}), online: true}
+}
+
+func (s *Site) ID() int         { return s.id }
+func (s *Site) Capacity() int   { return s.capacity }
+func (s *Site) Stored() int     { return len(s.knownKeys) }
+func (s *Site) ReadHits() int   { return int(s.readHits.Load()) }
+func (s *Site) ReadMisses() int { return int(s.readMisses.Load()) }
+func (s *Site) Evictions() int  { return s.evictions }
+
+// Name returns the site's name, or "" if it hasn't been given one.
+func (s *Site) Name() string { return s.name }
+
+func (s *Site) SetName(name string) {
+	s.name = name
+}
+
+// Label returns the site's name, or its id if it has no name, for output.
+func (s *Site) Label() string {
+	if s.name != "" {
+		return s.name
+	}
+	return strconv.Itoa(s.id)
+}
+
+// Zone returns the failure domain the site is in, or "" if it hasn't been
+// given one.
+func (s *Site) Zone() string { return s.zone }
+
+func (s *Site) SetZone(zone string) {
+	s.zone = zone
+}
+
+// ReadOnly reports whether the site serves reads but takes no writes.
+func (s *Site) ReadOnly() bool { return s.readOnly }
+
+func (s *Site) SetReadOnly(readOnly bool) {
+	s.readOnly = readOnly
+}
+
+// WriteOnly reports whether the site takes writes but serves no reads.
+func (s *Site) WriteOnly() bool { return s.writeOnly }
+
+func (s *Site) SetWriteOnly(writeOnly bool) {
+	s.writeOnly = writeOnly
+}
+
+// Online reports whether the site is up. Sites start online.
+func (s *Site) Online() bool { return s.online }
+
+func (s *Site) SetOnline(online bool) {
+	s.online = online
+}
+
+// SetCountReads controls whether reads are counted as hits and misses, such as
+// to leave out a burn-in period. Reads are counted by default.
+func (s *Site) SetCountReads(count bool) {
+	s.uncounted = !count
+}
+
+// TrackKeyReads starts counting read hits per key, for TopKeys.
+func (s *Site) TrackKeyReads() {
+	if s.keyReads == nil {
+		s.keyReads = make(map[string]int)
+	}
+}
+
+// KeyCount is a key and the number of times something happened to it.
+type KeyCount struct {
+	Key   string `json:"key"`
+	Count int    `json:"count"`
+}
+
+// TopKeys returns the n keys with the most read hits since TrackKeyReads was
+// called, most first, breaking ties by key.
+func (s *Site) TopKeys(n int) []KeyCount {
+	var top []KeyCount
+	s.keyReadsMu.Lock()
+	for k, c := range s.keyReads {
+		top = append(top, KeyCount{Key: k, Count: c})
+	}
+	s.keyReadsMu.Unlock()
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Count != top[j].Count {
+			return top[i].Count > top[j].Count
+		}
+		return top[i].Key < top[j].Key
+	})
+	if len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
+
+// Reset empties the site and zeroes its stats, bringing it back online, as if
+// it had just been created. Its id and capacity are kept.
+func (s *Site) Reset() {
+	clear(s.knownKeys)
+	s.order = s.order[:0]
+	s.readHits.Store(0)
+	s.readMisses.Store(0)
+	s.evictions = 0
+	s.online = true
+	s.uncounted = false
+	clear(s.keyReads)
+}
+
+// SetCapacity changes the site's capacity. Keys already stored beyond a
+// reduced capacity are kept.
+func (s *Site) SetCapacity(capacity int) {
+	s.capacity = capacity
+}
+
+func (s *Site) Full() bool {
+	return len(s.knownKeys) >= s.capacity
+}
+
+// Has reports whether the site holds key. Unlike HandleRead it does not count
+// as a read.
+func (s *Site) Has(key string) bool {
+	_, ok := s.knownKeys[key]
+	return ok
+}
+
+// Keys returns the keys held by the site in no particular order.
+func (s *Site) Keys() []string {
+	keys := make([]string, 0, len(s.knownKeys))
+	for k := range s.knownKeys {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// HandleWrite stores key. If the site is full, the oldest key is evicted to
+// make room and returned.
+func (s *Site) HandleWrite(key string) (evicted string, ok bool) {
+	if _, exists := s.knownKeys[key]; exists {
+		return "", false
+	}
+	if s.Full() && len(s.order) > 0 {
+		evicted, s.order = s.order[0], s.order[1:]
+		delete(s.knownKeys, evicted)
+		s.evictions++
+		ok = true
+	}
+	s.knownKeys[key] = struct{}{}
+	s.order = append(s.order, key)
+	return evicted, ok
+}
+
+// HandleRead reports whether the site holds key, counting the read as a hit
+// or miss. It's safe to call concurrently, as long as nothing writes to the
+// site meanwhile.
+func (s *Site) HandleRead(key string) bool {
+	_, ok := s.knownKeys[key]
+	switch {
+	case s.uncounted:
+	case ok:
+		s.readHits.Add(1)
+		if s.keyReads != nil {
+			s.keyReadsMu.Lock()
+			s.keyReads[key]++
+			s.keyReadsMu.Unlock()
+		}
+	default:
+		s.readMisses.Add(1)
+	}
+	return ok
+}
+
+// HandleDelete removes key, freeing its space, and reports whether the site
+// held it.
+func (s *Site) HandleDelete(key string) bool {
+	if !s.Has(key) {
+		return false
+	}
+	s.drop(key)
+	return true
+}
+
+// drop removes key from the site, if it holds it.
+func (s *Site) drop(key string) {
+	if _, ok := s.knownKeys[key]; !ok {
+		return
+	}
+	delete(s.knownKeys, key)
+	for i, k := range s.order {
+		if k == key {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package hashing
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// TestConcurrentReads reads a site from many goroutines, which `go test
+// -race` checks for races, and expects every read counted once.
+func TestConcurrentReads(t *testing.T) {
+	const goroutines, reads = 16, 1000
+	s := NewSite(1, 100)
+	for i := 0; i < 50; i++ {
+		s.HandleWrite(strconv.Itoa(i))
+	}
+	s.TrackKeyReads()
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < reads; i++ {
+				s.HandleRead(strconv.Itoa(i % 100))
+			}
+		}()
+	}
+	wg.Wait()
+	if got, want := s.ReadHits()+s.ReadMisses(), goroutines*reads; got != want {
+		t.Errorf("hits %d + misses %d = %d, want %d", s.ReadHits(), s.ReadMisses(), got, want)
+	}
+	if got, want := s.ReadHits(), goroutines*reads/2; got != want {
+		t.Errorf("hits = %d, want %d", got, want)
+	}
+	var tracked int
+	for _, kc := range s.TopKeys(100) {
+		tracked += kc.Count
+	}
+	if tracked != s.ReadHits() {
+		t.Errorf("TopKeys counts %d hits, want %d", tracked, s.ReadHits())
+	}
+}
+
+func TestReset(t *testing.T) {
+	s := NewSite(7, 3)
+	for _, key := range []string{"a", "b", "c", "d"} {
+		s.HandleWrite(key)
+	}
+	s.HandleRead("b")
+	s.HandleRead("z")
+	s.SetOnline(false)
+	s.Reset()
+	if s.Stored() != 0 || s.ReadHits() != 0 || s.ReadMisses() != 0 || s.Evictions() != 0 {
+		t.Errorf("after Reset: stored %d, hits %d, misses %d, evictions %d; want all 0", s.Stored(), s.ReadHits(), s.ReadMisses(), s.Evictions())
+	}
+	if s.ID() != 7 || s.Capacity() != 3 || !s.Online() {
+		t.Errorf("after Reset: id %d, capacity %d, online %t; want 7, 3, true", s.ID(), s.Capacity(), s.Online())
+	}
+	// The site should fill up again as if new.
+	for _, key := range []string{"a", "b", "c"} {
+		if evicted, ok := s.HandleWrite(key); ok {
+			t.Errorf("HandleWrite(%q) after Reset evicted %q", key, evicted)
+		}
+	}
+	if s.Stored() != 3 {
+		t.Errorf("after refilling: stored %d, want 3", s.Stored())
+	}
+}
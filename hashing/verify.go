@@ -0,0 +1,48 @@
+package hashing
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Verify checks that each of keys is held by exactly its top rf sites, and
+// returns an error for each key that isn't.
+func (r *Ring) Verify(keys []string, rf int) []error {
+	return VerifyPlacement(r.sites, r.TopSites, keys, rf)
+}
+
+// VerifyPlacement checks that each of keys is held by exactly the sites that
+// top returns for it, and returns an error for each key that isn't. It lets
+// placement algorithms outside this package share Ring.Verify.
+func VerifyPlacement(sites []*Site, top func(key string, n int) []*Site, keys []string, rf int) []error {
+	var errs []error
+	for _, key := range keys {
+		var held, want []int
+		for _, s := range sites {
+			if s.Has(key) {
+				held = append(held, s.id)
+			}
+		}
+		for _, s := range top(key, rf) {
+			want = append(want, s.id)
+		}
+		sort.Ints(held)
+		sort.Ints(want)
+		if !equalInts(held, want) {
+			errs = append(errs, fmt.Errorf("key %s is held by sites %v, want %v", key, held, want))
+		}
+	}
+	return errs
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
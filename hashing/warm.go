@@ -0,0 +1,25 @@
+package hashing
+
+// WarmNewSite copies onto s, which has just joined the ring, each of keys that
+// it is now one of the top rf sites for, as a node being added would be
+// pre-warmed rather than filling in as keys are rewritten. See WarmSite.
+func (r *Ring) WarmNewSite(s *Site, keys []string, rf int) (copied int) {
+	return WarmSite(s, r.TopSites, keys, rf)
+}
+
+// WarmSite writes each of keys that s is among the sites top returns for onto
+// s, while s has room. Other sites are left holding their copies. It returns
+// the number of keys copied, the cost of the warm-up. It lets placement
+// algorithms outside this package share Ring.WarmNewSite.
+func WarmSite(s *Site, top func(key string, n int) []*Site, keys []string, rf int) (copied int) {
+	for _, key := range keys {
+		if s.Full() {
+			break
+		}
+		if !s.Has(key) && containsSite(top(key, rf), s) {
+			s.HandleWrite(key)
+			copied++
+		}
+	}
+	return copied
+}
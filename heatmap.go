@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// heatmapKeys is the most keys a heatmap samples, so that it stays readable.
+const heatmapKeys = 200
+
+// heatmapCols is the number of columns the sampled keys are bucketed into.
+const heatmapCols = 40
+
+// heatmapShades are the characters cells are shaded with, emptiest first.
+const heatmapShades = " .:-=+*#%@"
+
+// heatmap is the share of each bucket of sampled keys that is placed on each
+// site.
+type heatmap struct {
+	// Keys is the number of keys sampled and KeysPerCol the number in each
+	// column, the last column holding any left over.
+	Keys, KeysPerCol int
+	Sites            []string
+	// Cells holds a row per site of the fraction of each column's keys
+	// whose top --rf sites include it.
+	Cells [][]float64
+}
+
+// newHeatmap buckets the first heatmapKeys of keys into columns of
+// consecutive keys and works out where ring places each.
+func newHeatmap(ring placer, keys []string) *heatmap {
+	if len(keys) > heatmapKeys {
+		keys = keys[:heatmapKeys]
+	}
+	perCol := (len(keys) + heatmapCols - 1) / heatmapCols
+	if perCol == 0 {
+		perCol = 1
+	}
+	cols := (len(keys) + perCol - 1) / perCol
+	hm := &heatmap{Keys: len(keys), KeysPerCol: perCol}
+	row := make(map[int]int)
+	for i, s := range ring.Sites() {
+		hm.Sites = append(hm.Sites, s.Label())
+		hm.Cells = append(hm.Cells, make([]float64, cols))
+		row[s.ID()] = i
+	}
+	for i, key := range keys {
+		col := i / perCol
+		size := min(perCol, len(keys)-col*perCol)
+		for _, s := range ring.TopSites(key, *replicationFactor) {
+			hm.Cells[row[s.ID()]][col] += 1 / float64(size)
+		}
+	}
+	return hm
+}
+
+// printHeatmap draws res.Heatmap as a grid with a row per site, shading each
+// cell by the share of its keys placed on the site, then the summary.
+func printHeatmap(w io.Writer, res result) error {
+	hm := res.Heatmap
+	fmt.Fprintf(w, "placement of the first %d keys, %d per column; shading %q from none to all of a column's keys\n", hm.Keys, hm.KeysPerCol, heatmapShades)
+	for i, label := range hm.Sites {
+		var b strings.Builder
+		for _, f := range hm.Cells[i] {
+			b.WriteByte(heatmapShades[int(f*float64(len(heatmapShades)-1)+0.5)])
+		}
+		fmt.Fprintf(w, "site %-4s |%s|\n", label, b.String())
+	}
+	return printSummary(w, res)
+}
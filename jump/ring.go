@@ -0,0 +1,121 @@
+// Package jump implements jump consistent hashing, for comparison against
+// rendezvous hashing. Jump hashing maps a key to one of n buckets, numbered in
+// the order sites were added; replicas go on the buckets after it.
+//
+// Jump hashing can't weight sites, so all sites should have the same
+// capacity, and only the most recently added site can be removed.
+//
+// https://arxiv.org/abs/1406.2294
+package jump
+
+import (
+	"example.com/mod/hashing"
+)
+
+// Ring is a set of sites treated as jump hash buckets.
+type Ring struct {
+	sites  []*hashing.Site
+	hasher hashing.Hasher
+}
+
+func NewRing(hasher hashing.Hasher, sites []*hashing.Site) *Ring {
+	return &Ring{sites: sites, hasher: hasher}
+}
+
+func (r *Ring) Sites() []*hashing.Site {
+	return r.sites
+}
+
+// AddSite adds s to the ring as a new last bucket.
+func (r *Ring) AddSite(s *hashing.Site) {
+	r.sites = append(r.sites, s)
+}
+
+// RemoveSite removes the site with the given id from the ring and returns it,
+// or nil if the ring has no such site or it isn't the last bucket, since jump
+// hashing can't remove any other. Slices previously returned by Sites are left
+// untouched.
+func (r *Ring) RemoveSite(id int) *hashing.Site {
+	last := len(r.sites) - 1
+	if last < 0 || r.sites[last].ID() != id {
+		return nil
+	}
+	s := r.sites[last]
+	r.sites = r.sites[:last:last]
+	return s
+}
+
+// RemapFraction returns the fraction of keys whose primary site differs
+// between the before and after memberships.
+func (r *Ring) RemapFraction(keys []string, before, after []*hashing.Site) float64 {
+	if len(keys) == 0 {
+		return 0
+	}
+	br, ar := NewRing(r.hasher, before), NewRing(r.hasher, after)
+	var moved int
+	for _, key := range keys {
+		b, a := br.TopSites(key, 1), ar.TopSites(key, 1)
+		if len(b) == 0 || len(a) == 0 || b[0] != a[0] {
+			moved++
+		}
+	}
+	return float64(moved) / float64(len(keys))
+}
+
+// Verify checks that each of keys is held by exactly its top rf sites, and
+// returns an error for each key that isn't.
+func (r *Ring) Verify(keys []string, rf int) []error {
+	return hashing.VerifyPlacement(r.sites, r.TopSites, keys, rf)
+}
+
+// Rebalance moves each of keys onto its current top rf sites, returning the
+// number of key copies written. See hashing.RebalancePlacement.
+func (r *Ring) Rebalance(keys []string, rf int) (moved int) {
+	return hashing.RebalancePlacement(r.sites, r.TopSites, keys, rf)
+}
+
+// WarmNewSite copies onto s each of keys it is now one of the top rf sites
+// for, returning the number copied. See hashing.WarmSite.
+func (r *Ring) WarmNewSite(s *hashing.Site, keys []string, rf int) (copied int) {
+	return hashing.WarmSite(s, r.TopSites, keys, rf)
+}
+
+// Reset empties every site on the ring. See hashing.ResetSites.
+func (r *Ring) Reset() {
+	hashing.ResetSites(r.sites)
+}
+
+// OrderedSites returns the ring's sites starting from key's bucket and
+// wrapping around.
+func (r *Ring) OrderedSites(key string) []*hashing.Site {
+	return r.TopSites(key, len(r.sites))
+}
+
+// TopSites returns key's bucket's site and the sites of the n-1 buckets after
+// it, wrapping around.
+func (r *Ring) TopSites(key string, n int) []*hashing.Site {
+	if n > len(r.sites) {
+		n = len(r.sites)
+	}
+	if n <= 0 {
+		return nil
+	}
+	b := Hash(r.hasher.Hash([]byte(key)), len(r.sites))
+	top := make([]*hashing.Site, n)
+	for i := range top {
+		top[i] = r.sites[(b+i)%len(r.sites)]
+	}
+	return top
+}
+
+// Hash returns the bucket in [0, buckets) for key, as in Lamping and Veach's
+// paper.
+func Hash(key uint64, buckets int) int {
+	var b, j int64 = -1, 0
+	for j < int64(buckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"example.com/mod/hashing"
+)
+
+// loadKeys returns the keys to write: the lines of --keyFile if set, otherwise
+// --numWrites synthetic keys, or --mixedOps for a mixed workload, drawn from
+// nextWrite. With --keyFile, --numWrites is set to the number of keys read.
+// With --namespaces, the keys are dealt round robin into namespaces;
+// sequential synthetic keys are numbered from 0 within each namespace, so the
+// same key appears in every namespace.
+func loadKeys(nextWrite func(i int) int) ([]string, error) {
+	if *keyFile == "" {
+		n := *numWrites
+		if *mixedOps > 0 {
+			n = *mixedOps
+		}
+		keys := make([]string, n)
+		for i := range keys {
+			keys[i] = syntheticKey(i, nextWrite(i))
+		}
+		return keys, nil
+	}
+
+	f, err := os.Open(*keyFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var keys []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if key := strings.TrimSpace(scanner.Text()); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading %s: %v", *keyFile, err)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s contains no keys", *keyFile)
+	}
+	if *namespaces > 1 {
+		for i, key := range keys {
+			keys[i] = namespaceKey(i%*namespaces, key)
+		}
+	}
+	return keys, nil
+}
+
+// sampleKeys returns n of keys picked uniformly at random without
+// replacement, in their original order.
+func sampleKeys(keys []string, n int, rng *rand.Rand) []string {
+	picked := rng.Perm(len(keys))[:n]
+	sort.Ints(picked)
+	sample := make([]string, n)
+	for i, j := range picked {
+		sample[i] = keys[j]
+	}
+	return sample
+}
+
+// scaleCapacities scales each site's capacity by n/of, so that n sampled keys
+// fill sites as all of keys would, keeping sites with capacity at least 1.
+func scaleCapacities(sites []*hashing.Site, n, of int) {
+	for _, s := range sites {
+		if s.Capacity() > 0 {
+			s.SetCapacity(max(1, int(math.Round(float64(s.Capacity())*float64(n)/float64(of)))))
+		}
+	}
+}
+
+// syntheticKey returns the i'th synthetic key, numbered k within its
+// namespace, or with --sparseKeys, k's sparse id.
+func syntheticKey(i, k int) string {
+	key := strconv.Itoa(k)
+	if *sparseKeys {
+		key = strconv.FormatUint(sparseKeyID(k), 10)
+	}
+	if *namespaces > 1 {
+		return namespaceKey(i%*namespaces, key)
+	}
+	return key
+}
+
+// sparseKeyID scatters key number k across all 64-bit values, as real ids
+// are, with the murmur3 finalizer. Offsetting k by --seed first draws a
+// different set of ids per seed. Both steps are bijective, so distinct
+// numbers still give distinct ids, and a key reads back as it was written.
+func sparseKeyID(k int) uint64 {
+	x := uint64(k) + uint64(*seed)*0x9e3779b97f4a7c15
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// newReadDist returns a generator of keys to read. Reads pick among keys per
+// the named distribution or, with --readsFollowWrites, are drawn from
+// nextWrite just as writes were, so the keys written most are read most.
+func newReadDist(name string, keys []string, nextWrite func(i int) int, rng *rand.Rand) (func() string, error) {
+	if *readsFollowWrites {
+		var i int
+		return func() string {
+			i++
+			return syntheticKey(i, nextWrite(i))
+		}, nil
+	}
+	next, err := newKeyDist(name, len(keys), rng)
+	if err != nil {
+		return nil, err
+	}
+	return func() string { return keys[next()] }, nil
+}
+
+// newWriteDist returns a generator of the synthetic key to write i'th.
+// sequential keys are all distinct; the others are drawn from --keySpace keys,
+// so some writes update a key already written.
+func newWriteDist(name string, rng *rand.Rand) (func(i int) int, error) {
+	switch name {
+	case "sequential":
+		return func(i int) int { return i / *namespaces }, nil
+	case "uniform":
+		return func(int) int { return rng.Intn(*keySpace) }, nil
+	case "zipf":
+		z := rand.NewZipf(rng, *zipfS, *zipfV, uint64(*keySpace-1))
+		if z == nil {
+			return nil, fmt.Errorf("invalid zipf parameters: want --zipfS > 1 and --zipfV >= 1, got %v and %v", *zipfS, *zipfV)
+		}
+		return func(int) int { return int(z.Uint64()) }, nil
+	case "repeat":
+		return func(i int) int { return i % *keySpace }, nil
+	}
+	return nil, fmt.Errorf("unknown --writeDist %q: want sequential, uniform, zipf or repeat", name)
+}
+
+// namespaceKey returns key in namespace ns. Sites hash "<site>-<ns>-<key>",
+// so equal keys in different namespaces are placed independently.
+func namespaceKey(ns int, key string) string {
+	return strconv.Itoa(ns) + "-" + key
+}
+
+// keyNamespace returns the namespace of a key built by namespaceKey.
+func keyNamespace(key string) int {
+	ns, _ := strconv.Atoi(key[:strings.IndexByte(key, '-')])
+	return ns
+}
+
+// newKeyDist returns a generator of key indexes in [0, n) following the named
+// distribution.
+func newKeyDist(name string, n int, rng *rand.Rand) (func() int, error) {
+	switch name {
+	case "uniform":
+		return func() int { return rng.Intn(n) }, nil
+	case "zipf":
+		z := rand.NewZipf(rng, *zipfS, *zipfV, uint64(n-1))
+		if z == nil {
+			return nil, fmt.Errorf("invalid zipf parameters: want --zipfS > 1 and --zipfV >= 1, got %v and %v", *zipfS, *zipfV)
+		}
+		return func() int { return int(z.Uint64()) }, nil
+	}
+	return nil, fmt.Errorf("unknown distribution %q: want uniform or zipf", name)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// logLevel is how much is logged besides the results.
+type logLevel int
+
+const (
+	// levelQuiet logs nothing, with --quiet.
+	levelQuiet logLevel = iota
+	// levelInfo logs progress messages, such as the effect of --churn.
+	levelInfo
+	// levelVerbose also logs each rejected write, with --verbose.
+	levelVerbose
+)
+
+// currentLevel returns the level set by --quiet or --verbose.
+func currentLevel() logLevel {
+	switch {
+	case *quiet:
+		return levelQuiet
+	case *verbose:
+		return levelVerbose
+	}
+	return levelInfo
+}
+
+// logf logs a message that isn't part of the results if level is enabled.
+// Messages go to stderr for machine-readable outputs so stdout stays
+// parseable.
+func logf(level logLevel, format string, a ...interface{}) {
+	if level > currentLevel() {
+		return
+	}
+	w := os.Stdout
+	if *output != "text" && *output != "heatmap" {
+		w = os.Stderr
+	}
+	fmt.Fprintf(w, format, a...)
+}
+
+// infof logs a progress message.
+func infof(format string, a ...interface{}) {
+	logf(levelInfo, format, a...)
+}
+
+// verbosef logs a message only wanted with --verbose.
+func verbosef(format string, a ...interface{}) {
+	logf(levelVerbose, format, a...)
+}
@@ -0,0 +1,197 @@
+// Package maglev implements Maglev hashing, for comparison against rendezvous
+// hashing. Keys are looked up in a table whose entries are filled by each site
+// in turn, following its own permutation of the table. Sites fill entries in
+// proportion to their capacity.
+//
+// https://research.google/pubs/pub44824/
+package maglev
+
+import (
+	"fmt"
+	"time"
+
+	"example.com/mod/hashing"
+)
+
+// DefaultTableSize is the default number of lookup table entries. It must be
+// prime so that every site's permutation covers the whole table.
+const DefaultTableSize = 65537
+
+// Ring is a Maglev lookup table over a set of sites.
+type Ring struct {
+	sites     []*hashing.Site
+	hasher    hashing.Hasher
+	size      int
+	table     []*hashing.Site
+	buildTime time.Duration
+}
+
+// NewRing returns a ring whose lookup table has size entries, which must be
+// prime.
+func NewRing(hasher hashing.Hasher, sites []*hashing.Site, size int) *Ring {
+	r := &Ring{sites: sites, hasher: hasher, size: size}
+	r.build()
+	return r
+}
+
+// withSites returns a ring over sites with the same table size as r.
+func (r *Ring) withSites(sites []*hashing.Site) *Ring {
+	return NewRing(r.hasher, sites, r.size)
+}
+
+// build fills the lookup table. Each round, every site earns credit in
+// proportion to its capacity relative to the largest, and spends each whole
+// unit of it claiming the next unclaimed entry in its permutation.
+func (r *Ring) build() {
+	start := time.Now()
+	defer func() { r.buildTime = time.Since(start) }()
+
+	r.table = make([]*hashing.Site, r.size)
+	var active []*hashing.Site
+	maxCap := 0
+	for _, s := range r.sites {
+		if s.Capacity() > 0 {
+			active = append(active, s)
+			if s.Capacity() > maxCap {
+				maxCap = s.Capacity()
+			}
+		}
+	}
+	if len(active) == 0 {
+		return
+	}
+
+	offsets := make([]uint64, len(active))
+	skips := make([]uint64, len(active))
+	next := make([]uint64, len(active))
+	credit := make([]float64, len(active))
+	m := uint64(r.size)
+	for i, s := range active {
+		offsets[i] = r.hasher.Hash([]byte(fmt.Sprintf("%d-offset", s.ID()))) % m
+		skips[i] = r.hasher.Hash([]byte(fmt.Sprintf("%d-skip", s.ID())))%(m-1) + 1
+	}
+	for filled := 0; filled < r.size; {
+		for i, s := range active {
+			credit[i] += float64(s.Capacity()) / float64(maxCap)
+			for ; credit[i] >= 1 && filled < r.size; credit[i]-- {
+				e := (offsets[i] + next[i]*skips[i]) % m
+				for r.table[e] != nil {
+					next[i]++
+					e = (offsets[i] + next[i]*skips[i]) % m
+				}
+				r.table[e] = s
+				next[i]++
+				filled++
+			}
+		}
+	}
+}
+
+// BuildTime returns how long the lookup table last took to build.
+func (r *Ring) BuildTime() time.Duration {
+	return r.buildTime
+}
+
+// TableSize returns the number of lookup table entries.
+func (r *Ring) TableSize() int {
+	return r.size
+}
+
+func (r *Ring) Sites() []*hashing.Site {
+	return r.sites
+}
+
+// AddSite adds s to the ring, rebuilding the lookup table.
+func (r *Ring) AddSite(s *hashing.Site) {
+	r.sites = append(r.sites, s)
+	r.build()
+}
+
+// RemoveSite removes the site with the given id from the ring and returns it,
+// or nil if the ring has no such site. Slices previously returned by Sites are
+// left untouched.
+func (r *Ring) RemoveSite(id int) *hashing.Site {
+	for i, s := range r.sites {
+		if s.ID() == id {
+			r.sites = append(r.sites[:i:i], r.sites[i+1:]...)
+			r.build()
+			return s
+		}
+	}
+	return nil
+}
+
+// RemapFraction returns the fraction of keys whose primary site differs
+// between the before and after memberships.
+func (r *Ring) RemapFraction(keys []string, before, after []*hashing.Site) float64 {
+	if len(keys) == 0 {
+		return 0
+	}
+	br, ar := r.withSites(before), r.withSites(after)
+	var moved int
+	for _, key := range keys {
+		b, a := br.TopSites(key, 1), ar.TopSites(key, 1)
+		if len(b) == 0 || len(a) == 0 || b[0] != a[0] {
+			moved++
+		}
+	}
+	return float64(moved) / float64(len(keys))
+}
+
+// Verify checks that each of keys is held by exactly its top rf sites, and
+// returns an error for each key that isn't.
+func (r *Ring) Verify(keys []string, rf int) []error {
+	return hashing.VerifyPlacement(r.sites, r.TopSites, keys, rf)
+}
+
+// Rebalance moves each of keys onto its current top rf sites, returning the
+// number of key copies written. See hashing.RebalancePlacement.
+func (r *Ring) Rebalance(keys []string, rf int) (moved int) {
+	return hashing.RebalancePlacement(r.sites, r.TopSites, keys, rf)
+}
+
+// WarmNewSite copies onto s each of keys it is now one of the top rf sites
+// for, returning the number copied. See hashing.WarmSite.
+func (r *Ring) WarmNewSite(s *hashing.Site, keys []string, rf int) (copied int) {
+	return hashing.WarmSite(s, r.TopSites, keys, rf)
+}
+
+// Reset empties every site on the ring. See hashing.ResetSites.
+func (r *Ring) Reset() {
+	hashing.ResetSites(r.sites)
+}
+
+// OrderedSites returns the ring's sites in the order they are first met
+// reading the lookup table from key's entry.
+func (r *Ring) OrderedSites(key string) []*hashing.Site {
+	return r.TopSites(key, len(r.sites))
+}
+
+// TopSites returns the site in key's lookup table entry followed by the next
+// n-1 distinct sites met reading on from it.
+func (r *Ring) TopSites(key string, n int) []*hashing.Site {
+	if n > len(r.sites) {
+		n = len(r.sites)
+	}
+	ordered := make([]*hashing.Site, 0, n)
+	seen := make(map[*hashing.Site]bool, n)
+	start := int(r.hasher.Hash([]byte(key)) % uint64(r.size))
+	for i := 0; i < r.size && len(ordered) < n; i++ {
+		s := r.table[(start+i)%r.size]
+		if s != nil && !seen[s] {
+			seen[s] = true
+			ordered = append(ordered, s)
+		}
+	}
+	// Sites so small they claimed no entries are never met in the table.
+	// Sites without capacity are decommissioned and never placed on.
+	for _, s := range r.sites {
+		if len(ordered) == n {
+			break
+		}
+		if !seen[s] && s.Capacity() > 0 {
+			ordered = append(ordered, s)
+		}
+	}
+	return ordered
+}
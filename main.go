@@ -1,6 +1,5 @@
-// https://en.wikipedia.org/wiki/Rendezvous_hashing
-// https://randorithms.com/2020/12/26/rendezvous-hashing.html
-// https://www.snia.org/sites/default/files/SDC15_presentations/dist_sys/Jason_Resch_New_Consistent_Hashings_Rev.pdf
+// Command sim-hashing simulates writes and reads against a set of sites
+// placed with weighted rendezvous hashing.
 package main
 
 import (
@@ -10,133 +9,879 @@ import (
 	"math"
 	"math/rand"
 	"os"
-	"sort"
+	"os/signal"
 	"strconv"
 	"strings"
+	"time"
+
+	"example.com/mod/consistent"
+	"example.com/mod/hashing"
+	"example.com/mod/jump"
+	"example.com/mod/maglev"
 )
 
+var configFile = flag.String("config", "", "JSON file of simulation parameters, keyed by flag name; flags passed on the command line take precedence")
 var replicationFactor = flag.Int("rf", 1, "replication factor")
-var numWrites = flag.Int("numWrites", 1000, "number of writes")
-var numReads = flag.Int("numReads", 10000, "number of reads, uniformly random to the site set")
+var numWrites = flag.Int("numWrites", 1000, "number of writes; ignored when --keyFile is set")
+var keyFile = flag.String("keyFile", "", "file of newline separated keys to write; when unset keys are the integers [0, numWrites)")
+var namespaces = flag.Int("namespaces", 1, "number of independent key namespaces that writes and reads are spread across")
+var perNamespaceStats = flag.Bool("perNamespaceStats", false, "break each site's stored keys down by namespace; needs --namespaces > 1")
+var writeDist = flag.String("writeDist", "sequential", "distribution of synthetic write keys: sequential distinct keys, or uniform, zipf or repeat (cycling) over --keySpace keys so some writes are updates")
+var sparseKeys = flag.Bool("sparseKeys", false, "make synthetic keys high-entropy 64-bit ids scattered across the whole range, instead of small sequential integers")
+var keySpace = flag.Int("keySpace", 100, "number of distinct synthetic keys written with a --writeDist other than sequential")
+var numReads = flag.Int("numReads", 10000, "number of reads, with keys drawn per --readDist")
+var burnIn = flag.Int("burnIn", 0, "number of reads at the start of the read phase left out of the read stats, to measure steady state")
+var replay = flag.Bool("replay", false, "instead of generating operations, replay lines of \"W <key>\" writes, \"R <key>\" reads and \"D <key>\" deletes from stdin until EOF")
+var sampleKeysN = flag.Int("sampleKeys", 0, "if set, write only this many keys sampled uniformly from those that would be written, on sites with proportionally scaled capacities, and extrapolate to all keys")
+var mixedOps = flag.Int("mixedOps", 0, "if set, do this many interleaved reads and writes instead of all writes then all reads; --numWrites, --numReads and --readDist are ignored")
+var ttl = flag.Int("ttl", 0, "if set, with --mixedOps, keys expire this many operations after they're written, freeing their space")
+var readRatio = flag.Float64("readRatio", 0.5, "fraction of --mixedOps operations that are reads")
+var readDist = flag.String("readDist", "uniform", "distribution of read keys: uniform or zipf")
+var readsFollowWrites = flag.Bool("readsFollowWrites", false, "draw read keys from the --writeDist zipf distribution that write keys were drawn from, instead of per --readDist")
+var zipfS = flag.Float64("zipfS", 1.1, "zipf s parameter, must be > 1; larger values concentrate reads on fewer keys")
+var zipfV = flag.Float64("zipfV", 1, "zipf v parameter, must be >= 1")
 var siteCaps = flag.String("siteCaps", "", "comma separated list of integers, each of which represents a site and its capacity")
+var siteNames = flag.String("siteNames", "", "comma separated list of names, one for each site in --siteCaps, shown in output and accepted by --failSites and --scaleSite in place of ids")
+var siteLatencies = flag.String("siteLatencies", "", "comma separated list of milliseconds a read takes to probe each site in --siteCaps, to report read latency percentiles")
+var siteZones = flag.String("siteZones", "", "comma separated list of zones, one for each site in --siteCaps; replicas are spread across distinct zones where possible")
+var maxCap = flag.Int64("maxCap", math.MaxInt32, "largest capacity --siteCaps may give a site, at most the largest 32-bit int so runs behave the same on every platform")
+var capMode = flag.String("capMode", "absolute", "how --siteCaps is read: absolute key counts, or relative weights sharing --totalCapacity")
+var totalCapacity = flag.Int("totalCapacity", 0, "total capacity split between sites by weight, with --capMode weight")
+var allowZeroCap = flag.String("allowZeroCap", "error", "how to treat sites with zero capacity: error, or skip them as decommissioned")
+var algo = flag.String("algo", "rendezvous", "placement algorithm: rendezvous, consistent, jump or maglev")
+var maglevSize = flag.Int("maglevSize", maglev.DefaultTableSize, "number of lookup table entries for --algo maglev; must be prime")
+var hashFunc = flag.String("hash", "maphash", "hash function used to score sites: maphash, fnv or crc64")
+var weighted = flag.Bool("weighted", true, "weight sites by capacity; when false sites are ordered purely by hash value")
+var invertOrder = flag.Bool("invertOrder", false, "developer aid: reverse --algo rendezvous's preference order, normally by descending score, so the least preferred site comes first")
+var scoreVariant = flag.String("scoreVariant", "classic", "how --algo rendezvous weights sites' hashes by capacity: classic, for shares proportional to capacity, logweight, weighting by the log of capacity, or freecap, an experiment weighting by free capacity so keys drift to emptier sites, compared against classic")
+var detectCollisions = flag.Bool("detectCollisions", false, "count the written keys for which two --algo rendezvous sites score identically, a sign the keys are stressing the hasher")
+var vnodes = flag.Int("vnodes", 1, "number of virtual nodes each site takes part in rendezvous scoring as")
+var seed = flag.Int64("seed", 0, "seed for reproducible runs; when unset each run differs. maphash can't be seeded, so with --seed it is replaced by seeded fnv")
+var readOnlySites = flag.String("readOnlySites", "", "comma separated list of site ids or names that serve reads but take no writes")
+var writeOnlySites = flag.String("writeOnlySites", "", "comma separated list of site ids or names that take writes but serve no reads")
+var failSites = flag.String("failSites", "", "comma separated list of site ids or names that go offline halfway through the writes")
+var repl = flag.Bool("repl", false, "if set, instead of running the simulation read commands such as place, write, read, stats, addsite and rmsite from stdin and apply them to the ring; type help for the list")
+var serve = flag.String("serve", "", "if set, e.g. :8080, serve placements over HTTP on this address instead of running the simulation; keys are placed but not written, so /stats shows sites empty")
+var rfSweep = flag.Int("rfSweep", 0, "if set, run the simulation once for each replication factor from 1 to this, ignoring --rf, and print a table comparing them")
+var workers = flag.Int("workers", 1, "number of goroutines that place keys in parallel during the write phase; writes are still applied in order")
+var disruptionTest = flag.Bool("disruptionTest", false, "instead of running the simulation, remove each site in turn, or with --algo jump the last, and check only the keys it was a top --rf site for change placement, exiting non-zero if any others move")
+var targetUtil = flag.Float64("targetUtil", 0, "if set, e.g. 0.8, instead of running the simulation suggest the smallest site capacities that would keep every site at most this full")
+var ownership = flag.Int("ownership", 0, "if set, e.g. 100000, instead of running the simulation place this many dense synthetic keys without storing them and report the fraction each site is primary for, against its share of capacity")
+var compareAdd = flag.Int("compareAdd", 0, "if set, instead of running the simulation write the first half of the keys under rendezvous and then consistent hashing, add a site of this capacity to each, and compare the fraction of written keys that remap and the copies needed to warm the new site")
+var compareSeedsN = flag.Int("compareSeeds", 0, "if set, instead of running the simulation place keys under this many hash seeds, counting up from --seed, and report how much each site's load varies and the most and least even seeds")
+var throughput = flag.Duration("throughput", 0, "if set, e.g. 10s, instead of running the simulation have --workers goroutines place keys for this long and report placements/sec, p99 latency and allocation rate")
+var bench = flag.Bool("bench", false, "time placing --numWrites keys instead of running the simulation")
+var checkAllReplicas = flag.Bool("checkAllReplicas", false, "as well as walking replicas until the first hit, check all of each read key's top --rf sites and report how many copies were found, to detect under-replication")
+var readQuorum = flag.Int("readQuorum", 1, "number of replicas that must hold a key for a read of it to hit")
+var readRepair = flag.Bool("readRepair", false, "when a read finds a key past a replica that missed it, backfill the key onto that replica")
+var explainKey = flag.String("key", "0", "key to explain, with the explain subcommand")
+var baseline = flag.String("baseline", "", "file holding an earlier run's --output json, to print how this run's metrics differ from it")
+var appendTo = flag.String("appendTo", "", "append a record of this run's parameters and key metrics to this file: a CSV row if it ends in .csv, otherwise a line of JSON")
+var traceFile = flag.String("traceFile", "", "file to stream each write's candidate and chosen sites to, as newline delimited JSON")
+var checkPlacement = flag.Int("checkPlacement", 0, "after the run, re-place this many sampled written keys and check each places on the same --rf sites it was written to, exiting non-zero if not")
+var verify = flag.Bool("verify", false, "after the run, check every written key is held by exactly its top --rf sites, exiting non-zero if not")
+var scaleSite = flag.String("scaleSite", "", "id or name of a site whose capacity changes to --scaleTo partway through the writes")
+var scaleTo = flag.Int("scaleTo", 0, "new capacity for --scaleSite")
+var scaleAt = flag.Float64("scaleAt", 0.5, "fraction of the way through the writes that --scaleSite is scaled")
+var decaySite = flag.String("decaySite", "", "id or name of a site whose capacity shrinks linearly across the run, as degrading hardware would")
+var decayRate = flag.Float64("decayRate", 0, "fraction of --decaySite's capacity lost by the end of the run")
+var rebalance = flag.Bool("rebalance", false, "once --scaleSite is scaled, move written keys onto their new top --rf sites and report how many copies moved")
+var dryRun = flag.Bool("dryRun", false, "only count where keys would be written and skip the reads, leaving sites empty")
+var writeAck = flag.Int("writeAck", 1, "minimum number of replicas a write must be placed on to succeed; writes that can't reach it are rejected without placing any")
+var replicaPlacement = flag.String("replicaPlacement", "topk", "how a key's --rf replicas are picked from its sites in preference order: topk, the most preferred, or spread, those at evenly spaced ranks 0, N/rf, 2N/rf and so on of the N sites")
+var writeStrategy = flag.String("writeStrategy", "all", "where writes go: all of the top --rf sites or none, best-effort to as many of them as can take it, overflow past those that can't onto later sites, or p2c, each replica on the less full of two sites sampled by capacity")
+var topKeys = flag.Int("topKeys", 0, "report this many of the most read keys on each site")
+var shares = flag.Bool("shares", false, "report each site's share of capacity, the share of keys weighting should give it, against its actual share of stored keys")
+var percentiles = flag.Bool("percentiles", false, "report the p50, p90 and p99 of per-site fullness")
+var pins = flag.String("pins", "", "comma separated list of key:site pairs pinning each key to a site id or name, which it's written to and read from first, ahead of its other replicas")
+var alertAt = flag.Float64("alertAt", 0, "if set, log the operation at which each site first becomes this fraction full, e.g. 0.9, and report the order sites crossed it in")
+var progress = flag.Int("progress", 0, "if set, print a progress line to stderr every this many operations, with how full the cluster is")
+var quiet = flag.Bool("quiet", false, "print only the summary, leaving out per-site lines and progress messages")
+var verbose = flag.Bool("verbose", false, "also log each rejected write with its key and the sites it tried")
+var output = flag.String("output", "text", "output format: text, json, csv, prometheus, or heatmap for a grid of where a sample of keys is placed")
+var onFull = flag.String("onFull", "reject", "what a write does when a replica site is full: reject the write, or evict the site's oldest key")
+var churn = flag.String("churn", "", "membership change applied halfway through the writes: add:<capacity> or remove:<site id>")
+var prewarm = flag.Bool("prewarm", false, "when --churn adds a site, copy the keys written so far that it's now a top --rf site for onto it")
 
-var siteCounter int
-
-type site struct {
-	id         int
-	capacity   int
-	knownKeys  map[int]struct{}
-	readHits   int
-	readMisses int
+// placer orders sites by preference for a key.
+type placer interface {
+	Sites() []*hashing.Site
+	AddSite(s *hashing.Site)
+	RemoveSite(id int) *hashing.Site
+	RemapFraction(keys []string, before, after []*hashing.Site) float64
+	OrderedSites(key string) []*hashing.Site
+	TopSites(key string, n int) []*hashing.Site
+	Verify(keys []string, rf int) []error
+	Rebalance(keys []string, rf int) (moved int)
+	WarmNewSite(s *hashing.Site, keys []string, rf int) (copied int)
+	Reset()
 }
 
-func newSite(capacity int) *site {
-	siteCounter++
-	return &site{id: siteCounter, capacity: capacity, knownKeys: make(map[int]struct{})}
-}
+func main() {
+	// The only subcommand is explain; without it the simulation runs.
+	args := os.Args[1:]
+	var explainCmd bool
+	if len(args) > 0 && args[0] == "explain" {
+		explainCmd, args = true, args[1:]
+	}
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s:\n", os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprint(flag.CommandLine.Output(), exitCodesUsage)
+	}
+	flag.CommandLine.Parse(args)
+	if *configFile != "" {
+		if err := loadConfig(*configFile); err != nil {
+			exit(flagError(err))
+		}
+	}
 
-func (s *site) full() bool {
-	return len(s.knownKeys) >= s.capacity
-}
+	if *siteCaps == "" {
+		exit(flagErrorf("please supply --siteCaps"))
+	}
 
-func (s *site) handleWrite(key int) {
-	s.knownKeys[key] = struct{}{}
-}
+	if *maxCap < 0 || *maxCap > math.MaxInt32 {
+		exit(flagErrorf("--maxCap %d must be between 0 and %d", *maxCap, math.MaxInt32))
+	}
+	caps, err := parseSiteCaps(*siteCaps, *maxCap)
+	if err != nil {
+		exit(flagError(err))
+	}
+	switch *capMode {
+	case "absolute":
+	case "weight":
+		if *totalCapacity <= 0 {
+			exit(flagErrorf("--capMode weight needs a positive --totalCapacity"))
+		}
+		if caps, err = weightedCapacities(caps, *totalCapacity, *maxCap); err != nil {
+			exit(flagError(err))
+		}
+	default:
+		exit(flagErrorf("unknown --capMode %q: want absolute or weight", *capMode))
+	}
+	sites := newSites(caps)
+	if *siteNames != "" {
+		names, err := parseSiteNames(*siteNames, len(sites))
+		if err != nil {
+			exit(flagError(err))
+		}
+		for i, s := range sites {
+			s.SetName(names[i])
+		}
+	}
+	if *siteZones != "" {
+		zones, err := parseSiteZones(*siteZones, len(sites))
+		if err != nil {
+			exit(flagError(err))
+		}
+		for i, s := range sites {
+			s.SetZone(zones[i])
+		}
+	}
 
-func (s *site) handleRead(key int) bool {
-	if _, ok := s.knownKeys[key]; ok {
-		s.readHits++
-		return true
+	if err := validateConfig(sites); err != nil {
+		exit(err)
 	}
-	s.readMisses++
-	return false
-}
 
-func main() {
-	flag.Parse()
+	failed, err := parseSiteIDs(*failSites, sites)
+	if err != nil {
+		exit(flagError(err))
+	}
 
-	if *siteCaps == "" {
-		fmt.Println("please supply --siteCaps")
-		os.Exit(1)
+	if err := setSiteRoles(sites); err != nil {
+		exit(flagError(err))
 	}
 
-	var sites []*site
-	for _, ss := range strings.Split(*siteCaps, ",") {
-		c, err := strconv.Atoi(ss)
+	var base *result
+	if *baseline != "" {
+		b, err := loadBaseline(*baseline)
 		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+			exit(err)
+		}
+		base = &b
+	}
+
+	scaled, err := parseSiteIDs(*scaleSite, sites)
+	if err != nil {
+		exit(flagError(err))
+	}
+
+	decayed, err := parseSiteIDs(*decaySite, sites)
+	if err != nil {
+		exit(flagError(err))
+	}
+
+	rngSeed := time.Now().UnixNano()
+	if flagSet("seed") {
+		rngSeed = *seed
+	}
+	rng := rand.New(rand.NewSource(rngSeed))
+
+	nextWrite, err := newWriteDist(*writeDist, rng)
+	if err != nil {
+		exit(flagError(err))
+	}
+	keys, err := loadKeys(nextWrite)
+	if err != nil {
+		exit(err)
+	}
+	sampledOf := len(keys)
+	if *sampleKeysN > 0 && *sampleKeysN < len(keys) {
+		keys = sampleKeys(keys, *sampleKeysN, rng)
+		scaleCapacities(sites, len(keys), sampledOf)
+	}
+	nextReadKey, err := newReadDist(*readDist, keys, nextWrite, rng)
+	if err != nil {
+		exit(flagError(err))
+	}
+
+	hasher, err := newHasher(*hashFunc)
+	if err != nil {
+		exit(flagError(err))
+	}
+	ring, err := newPlacer(*algo, hasher, sites)
+	if err != nil {
+		exit(flagError(err))
+	}
+
+	if explainCmd {
+		if err := explain(os.Stdout, ring, *explainKey); err != nil {
+			exit(err)
 		}
-		sites = append(sites, newSite(c))
+		return
 	}
 
-	if *replicationFactor > len(sites) {
-		fmt.Printf("replication factor %d is greater than num sites (%d)", replicationFactor, len(sites))
-		os.Exit(1)
+	if *serve != "" {
+		if err := runServe(ring, *serve); err != nil {
+			exit(err)
+		}
+		return
 	}
 
-	// Writes.
-	unableToWrite := make(map[int]struct{})
-	for key := 0; key < *numWrites; key++ {
-		sites := hashOrderedSites(sites, key)
-		allAvail := true
-		for i := 0; i < *replicationFactor; i++ {
-			allAvail = allAvail && !sites[i].full()
+	if *repl {
+		if err := runREPL(ring, os.Stdin, os.Stdout); err != nil {
+			exit(err)
 		}
-		if !allAvail {
-			unableToWrite[key] = struct{}{}
-			continue
+		return
+	}
+
+	if *rfSweep > 0 {
+		rows, err := runSweep(sites, hasher, keys, rngSeed)
+		if err == nil {
+			err = printSweep(os.Stdout, rows)
 		}
-		for i := 0; i < *replicationFactor; i++ {
-			sites[i].handleWrite(key)
+		if err != nil {
+			exit(err)
 		}
+		return
 	}
 
-	// Reads.
-	for i := 0; i < *numReads; i++ {
-		key := rand.Intn(*numWrites)
-		if _, ok := unableToWrite[key]; ok {
-			continue
+	if *bench {
+		runBench(ring, keys)
+		return
+	}
+
+	if *throughput > 0 {
+		runThroughput(ring, keys, *throughput)
+		return
+	}
+
+	if *compareAdd > 0 {
+		rows, err := runCompareAdd(sites, hasher, keys, *compareAdd)
+		if err == nil {
+			err = printCompareAdd(os.Stdout, rows)
 		}
-		for _, s := range hashOrderedSites(sites, key) {
-			if s.handleRead(key) {
-				break
-			}
+		if err != nil {
+			exit(err)
 		}
+		return
+	}
+
+	if *compareSeedsN > 0 {
+		cmp, err := compareSeeds(sites, keys)
+		if err == nil {
+			err = printSeedComparison(os.Stdout, cmp)
+		}
+		if err != nil {
+			exit(err)
+		}
+		return
+	}
+
+	if *ownership > 0 {
+		if err := printOwnership(os.Stdout, runOwnership(ring, *ownership), *ownership); err != nil {
+			exit(err)
+		}
+		return
+	}
+
+	if *targetUtil > 0 {
+		rows, iterations, err := suggestCapacities(sites, hasher, keys)
+		if err == nil {
+			err = printSizing(os.Stdout, rows, iterations)
+		}
+		if err != nil {
+			exit(err)
+		}
+		return
+	}
+
+	if *disruptionTest {
+		rows, err := runDisruption(ring, hasher, keys)
+		if err == nil {
+			err = printDisruption(os.Stdout, rows)
+		}
+		if err != nil {
+			exit(err)
+		}
+		var moved int
+		for _, r := range rows {
+			moved += r.Moved
+		}
+		// Spread replicas move by design, so are only reported.
+		if moved > 0 && *replicaPlacement != "spread" {
+			exit(checkFailed("disruption test: %d keys moved between surviving sites", moved))
+		}
+		return
+	}
+
+	sim := newSimulation(ring)
+	if *dryRun {
+		sim.tally = make(map[*hashing.Site]int)
+		sim.tallied = make(map[string]struct{})
+	}
+	sim.failed = failed
+	if *ttl > 0 {
+		sim.expiry = newExpiry(*ttl)
+	}
+	if *alertAt > 0 {
+		sim.alerts = &headroomAlerts{}
+	}
+	if *pins != "" {
+		sim.pins, err = parsePins(*pins, sites)
+		if err != nil {
+			exit(flagError(err))
+		}
+	}
+	if *checkPlacement > 0 {
+		sim.placements = newPlacementCheck(keys, *checkPlacement, rand.New(rand.NewSource(rngSeed)))
+	}
+	if *detectCollisions {
+		sim.collisions = &collisionCount{ring: ring.(*hashing.Ring)}
+	}
+	sim.distinctWrites = len(distinctKeys(keys)) == len(keys)
+	if *siteLatencies != "" {
+		latencies, err := parseSiteLatencies(*siteLatencies, len(sites))
+		if err != nil {
+			exit(flagError(err))
+		}
+		sim.latencies = make(map[*hashing.Site]float64)
+		for i, s := range sites {
+			sim.latencies[s] = latencies[i]
+		}
+	}
+	if len(keys) < sampledOf {
+		sim.sampledOf = sampledOf
+	}
+	if len(scaled) > 0 {
+		sim.scaleSite = siteByID(sites, scaled[0])
+	}
+	if len(decayed) > 0 {
+		s := siteByID(sites, decayed[0])
+		sim.decay = &decayResult{ID: s.ID(), From: s.Capacity(), To: int(math.Round(float64(s.Capacity()) * (1 - *decayRate))), site: s}
+	}
+
+	// On the first interrupt stop the run and print the stats so far. Later
+	// interrupts kill the process as usual.
+	stop := make(chan struct{})
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	go func() {
+		<-interrupts
+		signal.Stop(interrupts)
+		close(stop)
+	}()
+	sim.stop = stop
+
+	if *traceFile != "" {
+		if sim.trace, err = newTracer(*traceFile); err != nil {
+			exit(err)
+		}
+	}
+	switch {
+	case *replay:
+		err = sim.runReplay(os.Stdin)
+	case *mixedOps > 0:
+		err = sim.runMixed(keys, rng)
+	default:
+		err = sim.run(keys, nextReadKey)
+	}
+	if sim.trace != nil {
+		if cerr := sim.trace.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		exit(err)
 	}
 
 	// Print stats.
+	res := sim.result()
+	if *scoreVariant == "freecap" {
+		if res.ClassicScorer, err = compareClassic(sites, hasher, keys, rngSeed, failed); err != nil {
+			exit(err)
+		}
+	}
+	if *output == "heatmap" {
+		res.Heatmap = newHeatmap(ring, keys)
+	}
+	if err := printers[*output](os.Stdout, res); err != nil {
+		exit(err)
+	}
+	if base != nil {
+		w := os.Stdout
+		if *output != "text" && *output != "heatmap" {
+			w = os.Stderr
+		}
+		if err := printBaselineDiff(w, *base, res); err != nil {
+			exit(err)
+		}
+	}
+	if *appendTo != "" {
+		if err := appendRun(*appendTo, res); err != nil {
+			exit(err)
+		}
+	}
+
+	if *verify {
+		errs := ring.Verify(sim.writtenKeys(keys[:sim.writes]), *replicationFactor)
+		for _, err := range errs {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		if len(errs) > 0 {
+			exit(checkFailed("verify: %d keys not held by exactly their top %d sites", len(errs), *replicationFactor))
+		}
+	}
+
+	if *checkPlacement > 0 {
+		errs := sim.checkPlacements()
+		for _, err := range errs {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		if len(errs) > 0 {
+			exit(checkFailed("check placement: %d sampled keys place differently from where they were written", len(errs)))
+		}
+	}
+}
+
+// configError reports flags that are invalid or can't be used together.
+type configError struct {
+	msg string
+}
+
+func (e *configError) Error() string {
+	return e.msg
+}
+
+// validateConfig checks the flags against each other and the sites.
+func validateConfig(sites []*hashing.Site) error {
+	var active int
 	for _, s := range sites {
-		fmt.Printf("site %d: %d/%d (%.2f%% full)", s.id, len(s.knownKeys), s.capacity, float64(len(s.knownKeys))/float64(s.capacity)*100)
-		if *numReads == 0 {
-			fmt.Println()
-		} else {
-			fmt.Printf(". received reads: %d hits (%.2f%% of total), %d misses\n", s.readHits, float64(s.readHits)/float64(*numReads)*100, s.readMisses)
+		switch {
+		case s.Capacity() < 0:
+			return &configError{fmt.Sprintf("site %s has negative capacity %d", s.Label(), s.Capacity())}
+		case s.Capacity() == 0 && *allowZeroCap == "error":
+			return &configError{fmt.Sprintf("site %s has zero capacity; pass --allowZeroCap skip to treat it as decommissioned", s.Label())}
+		case s.Capacity() > 0:
+			active++
+		}
+	}
+	if *allowZeroCap != "error" && *allowZeroCap != "skip" {
+		return flagErrorf("unknown --allowZeroCap %q: want skip or error", *allowZeroCap)
+	}
+	if active == 0 {
+		return &configError{"no site has any capacity"}
+	}
+	if *replicationFactor > active {
+		return &configError{fmt.Sprintf("replication factor %d is greater than num sites with capacity (%d)", *replicationFactor, active)}
+	}
+	if *scaleSite != "" && (strings.Contains(*scaleSite, ",") || *scaleTo <= 0 || *scaleAt < 0 || *scaleAt > 1) {
+		return &configError{"--scaleSite needs a single site id, a positive --scaleTo and --scaleAt between 0 and 1"}
+	}
+	if *writeDist != "sequential" && *keySpace < 1 {
+		return &configError{fmt.Sprintf("--keySpace %d is not positive", *keySpace)}
+	}
+	if *readsFollowWrites && (*writeDist != "zipf" || *keyFile != "" || *mixedOps > 0) {
+		return &configError{"--readsFollowWrites needs --writeDist zipf, and can't be used with --keyFile or --mixedOps"}
+	}
+	if *namespaces < 1 {
+		return &configError{fmt.Sprintf("--namespaces %d is not positive", *namespaces)}
+	}
+	if *perNamespaceStats && (*namespaces < 2 || *dryRun) {
+		return &configError{"--perNamespaceStats needs --namespaces > 1 and can't be used with --dryRun"}
+	}
+	if *algo == "jump" {
+		for _, s := range sites {
+			if s.Capacity() != sites[0].Capacity() {
+				return &configError{fmt.Sprintf("--algo jump can't weight sites, so needs every site to have the same capacity; site %s has %d, site %s has %d", sites[0].Label(), sites[0].Capacity(), s.Label(), s.Capacity())}
+			}
+		}
+		if *churn != "" && strings.HasPrefix(*churn, "remove:") && *churn != fmt.Sprintf("remove:%d", sites[len(sites)-1].ID()) {
+			return &configError{fmt.Sprintf("--algo jump can only remove the last site, %d", sites[len(sites)-1].ID())}
+		}
+	}
+	if (*algo == "consistent" || *algo == "maglev") && (*scaleSite != "" || *decaySite != "") {
+		return &configError{fmt.Sprintf("--algo %s weights sites when the ring is built, so can't follow --scaleSite or --decaySite changing capacities", *algo)}
+	}
+	if *scoreVariant != "classic" && *algo != "rendezvous" {
+		return &configError{"--scoreVariant only applies to --algo rendezvous"}
+	}
+	if *scoreVariant == "freecap" && (!*weighted || *workers > 1 || *replay || *dryRun || *verify || *checkPlacement > 0 || *rebalance || *rfSweep > 0 || *churn != "" || *scaleSite != "" || *decaySite != "" || *pins != "" || *ttl > 0 || *writeStrategy == "p2c") {
+		return &configError{"--scoreVariant freecap places keys by what sites store, so needs --weighted and can't be used with --workers, --replay, --dryRun, --verify, --checkPlacement, --rebalance, --rfSweep, --churn, --scaleSite, --decaySite, --pins, --ttl or --writeStrategy p2c"}
+	}
+	if *detectCollisions && *algo != "rendezvous" {
+		return &configError{"--detectCollisions only applies to --algo rendezvous"}
+	}
+	if *checkPlacement < 0 {
+		return &configError{fmt.Sprintf("--checkPlacement %d must not be negative", *checkPlacement)}
+	}
+	if *checkPlacement > 0 && (*churn != "" || *scaleSite != "" || *decaySite != "" || *replay) {
+		return &configError{"--checkPlacement can't be used with --churn, --scaleSite or --decaySite, which change placement partway through, or --replay, which doesn't know its keys up front"}
+	}
+	if *invertOrder && *algo != "rendezvous" {
+		return &configError{"--invertOrder only applies to --algo rendezvous"}
+	}
+	if *algo == "maglev" && !isPrime(*maglevSize) {
+		return &configError{fmt.Sprintf("--maglevSize %d is not prime", *maglevSize)}
+	}
+	if *rfSweep > active {
+		return &configError{fmt.Sprintf("--rfSweep %d is greater than num sites with capacity (%d)", *rfSweep, active)}
+	}
+	if *rfSweep > 0 && (*failSites != "" || *scaleSite != "" || *churn != "" || *dryRun || *ttl > 0 || *pins != "" || *siteLatencies != "" || (*output != "text" && *output != "json")) {
+		return &configError{"--rfSweep can't be used with --failSites, --scaleSite, --churn, --dryRun, --ttl, --pins or --siteLatencies, and only supports text or json output"}
+	}
+	if *sampleKeysN < 0 || (*sampleKeysN > 0 && (*replay || *rfSweep > 0)) {
+		return &configError{fmt.Sprintf("--sampleKeys %d needs to be positive, and can't be used with --replay or --rfSweep", *sampleKeysN)}
+	}
+	if *decaySite != "" && (strings.Contains(*decaySite, ",") || *decayRate <= 0 || *decayRate > 1 || *workers > 1 || *replay || *rfSweep > 0) {
+		return &configError{"--decaySite needs a single site and --decayRate between 0 and 1, and can't be used with --workers, --replay or --rfSweep"}
+	}
+	if *siteZones != "" && (*verify || *rebalance) {
+		return &configError{"--siteZones can't be used with --verify or --rebalance, which expect replicas on the top --rf sites"}
+	}
+	if *prewarm && (!strings.HasPrefix(*churn, "add:") || *dryRun) {
+		return &configError{"--prewarm needs --churn add:<capacity> and can't be used with --dryRun"}
+	}
+	if *targetUtil < 0 || *targetUtil > 1 || (*targetUtil > 0 && *output != "text" && *output != "json") {
+		return &configError{fmt.Sprintf("--targetUtil %v needs to be between 0 and 1, with text or json output", *targetUtil)}
+	}
+	if *compareAdd < 0 || (*compareAdd > 0 && (*writeStrategy == "p2c" || (*output != "text" && *output != "json"))) {
+		return &configError{fmt.Sprintf("--compareAdd %d needs to be positive, with text or json output, and can't be used with --writeStrategy p2c", *compareAdd)}
+	}
+	if *compareSeedsN < 0 || (*compareSeedsN > 0 && *output != "text" && *output != "json") {
+		return &configError{fmt.Sprintf("--compareSeeds %d needs to be positive, with text or json output", *compareSeedsN)}
+	}
+	if *ownership < 0 {
+		return &configError{fmt.Sprintf("--ownership %d must not be negative", *ownership)}
+	}
+	if *ownership > 0 && *output != "text" && *output != "json" {
+		return &configError{"--ownership only supports text or json output"}
+	}
+	if *disruptionTest && *output != "text" && *output != "json" {
+		return &configError{"--disruptionTest only supports text or json output"}
+	}
+	if *replay && (*mixedOps > 0 || *rfSweep > 0 || *churn != "" || *failSites != "" || *scaleSite != "" || *verify) {
+		return &configError{"--replay can't be used with --mixedOps, --rfSweep, --churn, --failSites, --scaleSite or --verify, which need to know the workload's length or keys up front"}
+	}
+	if *rebalance && (*scaleSite == "" || *dryRun) {
+		return &configError{"--rebalance needs --scaleSite and can't be used with --dryRun"}
+	}
+	if *workers < 1 {
+		return &configError{fmt.Sprintf("--workers %d is not positive", *workers)}
+	}
+	if *ttl < 0 {
+		return &configError{fmt.Sprintf("--ttl %d must not be negative", *ttl)}
+	}
+	if *ttl > 0 && (*mixedOps == 0 || *dryRun || *churn != "" || *rebalance) {
+		return &configError{"--ttl needs --mixedOps and can't be used with --dryRun, --churn or --rebalance, which store or move keys outside of writes"}
+	}
+	if *workers > 1 && *mixedOps > 0 {
+		return &configError{"--workers can't be used with --mixedOps"}
+	}
+	if (*throughput > 0 || *bench) && *keyFile == "" && *numWrites < 1 && *mixedOps < 1 {
+		return &configError{"--throughput and --bench need keys to place: a positive --numWrites, or a --keyFile"}
+	}
+	if *numWrites < 0 {
+		return &configError{fmt.Sprintf("--numWrites %d must not be negative", *numWrites)}
+	}
+	// Reads pick among the written keys, except with --readsFollowWrites,
+	// and --serve, --repl and --replay don't read them at all.
+	if *numReads > 0 && *keyFile == "" && *numWrites == 0 && *mixedOps < 1 && !*readsFollowWrites && *serve == "" && !*repl && !*replay {
+		return &configError{fmt.Sprintf("--numReads %d needs keys to read: a positive --numWrites, or a --keyFile", *numReads)}
+	}
+	if *pins != "" && (*writeStrategy == "p2c" || *verify || *siteZones != "") {
+		return &configError{"--pins can't be used with --writeStrategy p2c, --verify or --siteZones, which place keys their own way"}
+	}
+	if *alertAt < 0 || *alertAt > 1 {
+		return &configError{fmt.Sprintf("--alertAt %v is not between 0 and 1", *alertAt)}
+	}
+	if *writeAck < 1 || *writeAck > *replicationFactor {
+		return &configError{fmt.Sprintf("--writeAck %d is not between 1 and the replication factor %d", *writeAck, *replicationFactor)}
+	}
+	if *readQuorum < 1 || *readQuorum > *replicationFactor {
+		return &configError{fmt.Sprintf("--readQuorum %d is not between 1 and the replication factor %d", *readQuorum, *replicationFactor)}
+	}
+	if *readRatio < 0 || *readRatio > 1 {
+		return &configError{fmt.Sprintf("--readRatio %v is not between 0 and 1", *readRatio)}
+	}
+	switch *writeStrategy {
+	case "all", "best-effort", "overflow":
+	case "p2c":
+		if *siteZones != "" || *workers > 1 || *verify || *targetUtil > 0 || *rfSweep > 0 {
+			return &configError{"--writeStrategy p2c doesn't place keys by hashing, so can't be used with --siteZones, --workers, --verify, --targetUtil or --rfSweep"}
+		}
+	default:
+		return flagErrorf("unknown --writeStrategy %q: want all, best-effort, overflow or p2c", *writeStrategy)
+	}
+	switch *replicaPlacement {
+	case "topk":
+	case "spread":
+		if *siteZones != "" || *pins != "" || *writeStrategy == "p2c" || *verify || *rebalance || *churn != "" {
+			return &configError{"--replicaPlacement spread can't be used with --siteZones, --pins or --writeStrategy p2c, which pick replicas their own way, or --verify, --rebalance or --churn, which expect replicas on the top --rf sites"}
 		}
+	default:
+		return flagErrorf("unknown --replicaPlacement %q: want topk or spread", *replicaPlacement)
+	}
+	if *onFull != "reject" && *onFull != "evict" {
+		return flagErrorf("unknown --onFull %q: want evict or reject", *onFull)
 	}
-	fmt.Printf("unable to write: %d (%.2f%%)\n", len(unableToWrite), float64(len(unableToWrite))/float64(*numWrites)*100)
+	if *quiet && *verbose {
+		return &configError{"--quiet and --verbose can't be used together"}
+	}
+	if _, ok := printers[*output]; !ok {
+		return flagErrorf("unknown --output %q: want text, json, csv, prometheus or heatmap", *output)
+	}
+	return nil
 }
 
-var seed = maphash.MakeSeed()
+// isPrime reports whether n is prime.
+func isPrime(n int) bool {
+	if n < 2 {
+		return false
+	}
+	for d := 2; d*d <= n; d++ {
+		if n%d == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// parseSiteIDs parses a comma separated list of site ids or names, each of
+// which must belong to one of sites, and returns their ids.
+func parseSiteIDs(s string, sites []*hashing.Site) ([]int, error) {
+	if s == "" {
+		return nil, nil
+	}
+	known := make(map[int]bool)
+	named := make(map[string]int)
+	for _, site := range sites {
+		known[site.ID()] = true
+		if site.Name() != "" {
+			named[site.Name()] = site.ID()
+		}
+	}
+	var ids []int
+	for _, f := range strings.Split(s, ",") {
+		if id, ok := named[strings.TrimSpace(f)]; ok {
+			ids = append(ids, id)
+			continue
+		}
+		id, err := strconv.Atoi(strings.TrimSpace(f))
+		if err != nil {
+			return nil, fmt.Errorf("invalid site id %q: %v", f, err)
+		}
+		if !known[id] {
+			return nil, fmt.Errorf("no site with id %d", id)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// parsePins parses a comma separated list of key:site pairs, where each site
+// is an id or name belonging to sites, into each key's site. The key is
+// everything before the last colon, so it may contain colons itself.
+func parsePins(s string, sites []*hashing.Site) (map[string]*hashing.Site, error) {
+	byID := make(map[int]*hashing.Site)
+	for _, site := range sites {
+		byID[site.ID()] = site
+	}
+	pinned := make(map[string]*hashing.Site)
+	for _, f := range strings.Split(s, ",") {
+		i := strings.LastIndex(f, ":")
+		if i <= 0 || strings.TrimSpace(f[i+1:]) == "" {
+			return nil, fmt.Errorf("invalid pin %q: want key:site", f)
+		}
+		ids, err := parseSiteIDs(f[i+1:], sites)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pin %q: %v", f, err)
+		}
+		key := strings.TrimSpace(f[:i])
+		if _, ok := pinned[key]; ok {
+			return nil, fmt.Errorf("key %s is pinned more than once", key)
+		}
+		pinned[key] = byID[ids[0]]
+	}
+	return pinned, nil
+}
 
-func hashOrderedSites(sites []*site, key int) []*site {
-	type indexedSite struct {
-		*site
-		num float64
+// setSiteRoles marks the sites listed by --readOnlySites and --writeOnlySites.
+// A site can't be both.
+func setSiteRoles(sites []*hashing.Site) error {
+	readOnly, err := parseSiteIDs(*readOnlySites, sites)
+	if err != nil {
+		return err
+	}
+	writeOnly, err := parseSiteIDs(*writeOnlySites, sites)
+	if err != nil {
+		return err
 	}
-	var indexedSites []*indexedSite
+	byID := make(map[int]*hashing.Site)
 	for _, s := range sites {
-		hashKey := fmt.Sprintf("%d-%d", s.id, key)
-		c := float64(maphash.String(seed, hashKey)) / float64(math.MaxUint64)
-		checksum := -1 * float64(s.capacity) / math.Log(c)
-		indexedSites = append(indexedSites, &indexedSite{site: s, num: checksum})
+		byID[s.ID()] = s
 	}
-	sort.Slice(indexedSites, func(i, j int) bool {
-		return indexedSites[i].num > indexedSites[j].num
-	})
-	var ordered []*site
-	for _, s := range indexedSites {
-		ordered = append(ordered, s.site)
+	for _, id := range readOnly {
+		byID[id].SetReadOnly(true)
+	}
+	for _, id := range writeOnly {
+		if byID[id].ReadOnly() {
+			return fmt.Errorf("site %s can't be both read-only and write-only", byID[id].Label())
+		}
+		byID[id].SetWriteOnly(true)
 	}
-	return ordered
+	return nil
+}
+
+// newSites returns a site for each of caps with that capacity, numbered from 1
+// in order.
+func newSites(caps []int) []*hashing.Site {
+	var sites []*hashing.Site
+	for i, c := range caps {
+		sites = append(sites, hashing.NewSite(i+1, c))
+	}
+	return sites
+}
+
+// newSitesLike returns fresh sites with the ids, names and zones of sites but
+// caps as their capacities.
+func newSitesLike(sites []*hashing.Site, caps []int) []*hashing.Site {
+	like := newSites(caps)
+	for i, s := range like {
+		s.SetName(sites[i].Name())
+		s.SetZone(sites[i].Zone())
+		s.SetReadOnly(sites[i].ReadOnly())
+		s.SetWriteOnly(sites[i].WriteOnly())
+	}
+	return like
+}
+
+// nextSiteID returns an id one more than the largest of sites', for a site
+// joining them.
+func nextSiteID(sites []*hashing.Site) int {
+	var id int
+	for _, s := range sites {
+		id = max(id, s.ID())
+	}
+	return id + 1
+}
+
+// siteByID returns the site in sites with the given id, or nil.
+func siteByID(sites []*hashing.Site, id int) *hashing.Site {
+	for _, s := range sites {
+		if s.ID() == id {
+			return s
+		}
+	}
+	return nil
+}
+
+func newPlacer(name string, hasher hashing.Hasher, sites []*hashing.Site) (placer, error) {
+	switch name {
+	case "rendezvous":
+		r := hashing.NewRing(hasher, sites)
+		r.SetWeighted(*weighted)
+		r.SetVNodes(*vnodes)
+		r.SetInverted(*invertOrder)
+		switch *scoreVariant {
+		case "classic":
+		case "logweight":
+			r.SetScoreFunc(hashing.LogWeightScore)
+		case "freecap":
+			r.SetWeightByFree(true)
+		default:
+			return nil, fmt.Errorf("unknown --scoreVariant %q: want classic, logweight or freecap", *scoreVariant)
+		}
+		return r, nil
+	case "consistent":
+		return consistent.NewRing(hasher, sites), nil
+	case "jump":
+		return jump.NewRing(hasher, sites), nil
+	case "maglev":
+		r := maglev.NewRing(hasher, sites, *maglevSize)
+		infof("maglev table of %d entries built in %v\n", r.TableSize(), r.BuildTime())
+		return r, nil
+	}
+	return nil, fmt.Errorf("unknown --algo %q: want rendezvous, consistent, jump or maglev", name)
+}
+
+// newHasher returns the named hasher, seeded with --seed if it was set.
+func newHasher(name string) (hashing.Hasher, error) {
+	if flagSet("seed") {
+		return newSeededHasher(name, *seed)
+	}
+	switch name {
+	case "maphash":
+		return hashing.NewMapHash(maphash.MakeSeed()), nil
+	case "fnv":
+		return hashing.FNV{}, nil
+	case "crc64":
+		return hashing.CRC64{}, nil
+	}
+	return nil, fmt.Errorf("unknown --hash %q: want maphash, fnv or crc64", name)
+}
+
+// seededHashName returns the hash newSeededHasher uses for the named one:
+// maphash can't be seeded, so it is replaced by fnv.
+func seededHashName(name string) string {
+	if name == "maphash" {
+		return "fnv"
+	}
+	return name
+}
+
+// newSeededHasher returns the named hasher, as seededHashName replaces it,
+// seeded with seed.
+func newSeededHasher(name string, seed int64) (hashing.Hasher, error) {
+	var h hashing.Hasher
+	switch seededHashName(name) {
+	case "fnv":
+		h = hashing.FNV{}
+	case "crc64":
+		h = hashing.CRC64{}
+	default:
+		return nil, fmt.Errorf("unknown --hash %q: want maphash, fnv or crc64", name)
+	}
+	return hashing.NewSeeded(seed, h), nil
+}
+
+// flagSet reports whether the named flag was passed on the command line.
+func flagSet(name string) bool {
+	var set bool
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
 }
@@ -0,0 +1,681 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"maps"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"example.com/mod/hashing"
+)
+
+// near reports whether a and b are equal to within rounding error.
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestLoadStats(t *testing.T) {
+	tests := []struct {
+		name               string
+		stats              []SiteStat
+		mean, stddev, gini float64
+	}{
+		{"empty", nil, 0, 0, 0},
+		{"even", []SiteStat{{Capacity: 10, Stored: 5}, {Capacity: 10, Stored: 5}}, 5, 0, 0},
+		{"proportional to capacity", []SiteStat{{Capacity: 10, Stored: 5}, {Capacity: 20, Stored: 10}}, 7.5, 2.5, 0},
+		// Ratios 0 and 1: the mean absolute difference over all 4 pairs
+		// is 0.5, over twice the mean ratio of 0.5.
+		{"one full one empty", []SiteStat{{Capacity: 10, Stored: 0}, {Capacity: 10, Stored: 10}}, 5, 5, 0.5},
+		{"decommissioned left out", []SiteStat{{Capacity: 10, Stored: 5}, {Capacity: 0}, {Capacity: 10, Stored: 5}}, 5, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mean, stddev, gini := loadStats(tt.stats)
+			if !near(mean, tt.mean) || !near(stddev, tt.stddev) || !near(gini, tt.gini) {
+				t.Errorf("loadStats() = %v, %v, %v, want %v, %v, %v", mean, stddev, gini, tt.mean, tt.stddev, tt.gini)
+			}
+		})
+	}
+}
+
+// setFlag sets the named flag to value for the rest of the test.
+func setFlag(t *testing.T, name, value string) {
+	t.Helper()
+	old := flag.Lookup(name).Value.String()
+	if err := flag.Set(name, value); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { flag.Set(name, old) })
+}
+
+// newTestRing returns a rendezvous ring over sites with the given
+// capacities, hashed with seeded fnv so placement is reproducible.
+func newTestRing(t *testing.T, caps ...int) placer {
+	t.Helper()
+	ring, err := newPlacer("rendezvous", hashing.NewSeeded(1, hashing.FNV{}), newSites(caps))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ring
+}
+
+func TestReadsOnlyCheckReplicas(t *testing.T) {
+	setFlag(t, "rf", "2")
+	ring := newTestRing(t, 100, 100, 100, 100)
+	sim := newSimulation(ring)
+	for i := 0; i < 100; i++ {
+		sim.write(strconv.Itoa(i))
+	}
+	for i := 0; i < 100; i++ {
+		sim.read(strconv.Itoa(i))
+	}
+	if len(sim.hops) > 2 {
+		t.Errorf("reads hit %d sites deep, want at most rf = 2", len(sim.hops))
+	}
+
+	// Leave a copy of a key on its third site, as an earlier run with a
+	// higher replication factor might have, then take its replicas down.
+	key := "0"
+	ordered := ring.OrderedSites(key)
+	ordered[2].HandleWrite(key)
+	ordered[0].SetOnline(false)
+	ordered[1].SetOnline(false)
+	hits := ordered[2].ReadHits()
+	sim.read(key)
+	if got := ordered[2].ReadHits() - hits; got != 0 {
+		t.Errorf("reading key %s hit its third site, which isn't a replica", key)
+	}
+}
+
+func TestZeroCapacities(t *testing.T) {
+	tests := []struct {
+		mode    string
+		caps    []int
+		wantErr bool
+	}{
+		{"error", []int{0, 0}, true},
+		{"skip", []int{0, 0}, true},
+		{"error", []int{0, 100}, true},
+		{"skip", []int{0, 100}, false},
+		{"skip", []int{100, 0, 100}, false},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s %v", tt.mode, tt.caps), func(t *testing.T) {
+			setFlag(t, "allowZeroCap", tt.mode)
+			sites := newSites(tt.caps)
+			err := validateConfig(sites)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validateConfig() = %v, want error %t", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			ring := newTestRing(t, tt.caps...)
+			for i := 0; i < 200; i++ {
+				for _, s := range ring.OrderedSites(strconv.Itoa(i)) {
+					if s.Capacity() == 0 {
+						t.Fatalf("key %d placed on decommissioned site %d", i, s.ID())
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestWeightedCapacities(t *testing.T) {
+	tests := []struct {
+		weights []int
+		total   int
+		max     int64
+		want    []int
+		wantErr bool
+	}{
+		{weights: []int{1, 2, 1}, total: 400, max: math.MaxInt32, want: []int{100, 200, 100}},
+		// 33.3 each: the one key left over goes to the first site.
+		{weights: []int{1, 1, 1}, total: 100, max: math.MaxInt32, want: []int{34, 33, 33}},
+		// 6.67 and 3.33: the larger remainder gets the key left over.
+		{weights: []int{2, 1}, total: 10, max: math.MaxInt32, want: []int{7, 3}},
+		{weights: []int{1, 2}, total: 10, max: math.MaxInt32, want: []int{3, 7}},
+		{weights: []int{1, 1}, total: 1, max: math.MaxInt32, want: []int{1, 0}},
+		{weights: []int{3, 0, 1}, total: 7, max: math.MaxInt32, want: []int{5, 0, 2}},
+		{weights: []int{0, 0}, total: 10, max: math.MaxInt32, want: []int{0, 0}},
+		// Capacities right at max are allowed, and one over isn't.
+		{weights: []int{1, 1}, total: 200, max: 100, want: []int{100, 100}},
+		{weights: []int{1, 1}, total: 201, max: 100, wantErr: true},
+		// Weight times total overflows 64 bits, but the capacities don't.
+		{weights: []int{math.MaxInt32, math.MaxInt32}, total: math.MaxInt - 1, max: math.MaxInt64, want: []int{math.MaxInt / 2, math.MaxInt / 2}},
+		{weights: []int{math.MaxInt32 - 1, 1}, total: math.MaxInt32, max: math.MaxInt32, want: []int{math.MaxInt32 - 1, 1}},
+		{weights: []int{math.MaxInt32, 1}, total: math.MaxInt, max: 1 << 20, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := weightedCapacities(tt.weights, tt.total, tt.max)
+		if (err != nil) != tt.wantErr || !slices.Equal(got, tt.want) {
+			t.Errorf("weightedCapacities(%v, %d, %d) = %v, %v, want %v, error %t", tt.weights, tt.total, tt.max, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestParseSiteCaps(t *testing.T) {
+	const defaultMax = math.MaxInt32
+	tests := []struct {
+		in      string
+		max     int64
+		want    []int
+		wantErr bool
+	}{
+		{in: "100,200", max: defaultMax, want: []int{100, 200}},
+		{in: "100,", max: defaultMax, want: []int{100}},
+		{in: " 100 , 200 ,,", max: defaultMax, want: []int{100, 200}},
+		{in: "0,5", max: defaultMax, want: []int{0, 5}},
+		{in: "", max: defaultMax, wantErr: true},
+		{in: " , ,", max: defaultMax, wantErr: true},
+		{in: "-1", max: defaultMax, wantErr: true},
+		{in: "100,-5", max: defaultMax, wantErr: true},
+		{in: "1e3", max: defaultMax, wantErr: true},
+		{in: "100 200", max: defaultMax, wantErr: true},
+		// At, just over and far over a non-default --maxCap.
+		{in: "5,1000", max: 1000, want: []int{5, 1000}},
+		{in: "5,1001", max: 1000, wantErr: true},
+		{in: "9223372036854775808", max: 1000, wantErr: true},
+		{in: "0", max: 0, want: []int{0}},
+		{in: "1", max: 0, wantErr: true},
+		{in: "2147483647", max: defaultMax, want: []int{math.MaxInt32}},
+		{in: "2147483648", max: defaultMax, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseSiteCaps(tt.in, tt.max)
+		if (err != nil) != tt.wantErr || !slices.Equal(got, tt.want) {
+			t.Errorf("parseSiteCaps(%q, %d) = %v, %v, want %v, error %t", tt.in, tt.max, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+// FuzzParseSiteCaps checks parseSiteCaps never panics, and either errors or
+// returns at least one capacity, none negative or over max.
+func FuzzParseSiteCaps(f *testing.F) {
+	for _, s := range []string{"-1", "9999999999999999999", ",,,", "100,200", " 0 , 5,", "2147483648"} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		caps, err := parseSiteCaps(s, math.MaxInt32)
+		if err != nil {
+			if caps != nil {
+				t.Errorf("parseSiteCaps(%q) = %v with error %v, want no caps", s, caps, err)
+			}
+			return
+		}
+		if len(caps) == 0 {
+			t.Errorf("parseSiteCaps(%q) returned no caps and no error", s)
+		}
+		for _, c := range caps {
+			if c < 0 || c > math.MaxInt32 {
+				t.Errorf("parseSiteCaps(%q) = %v, with %d out of range", s, caps, c)
+			}
+		}
+	})
+}
+
+func TestCapacitySharesPct(t *testing.T) {
+	tests := []struct {
+		name string
+		caps []int
+		want []float64
+	}{
+		{"even", []int{50, 50}, []float64{50, 50}},
+		// 100 of 400, 100 of 400 and 200 of 400.
+		{"uneven", []int{100, 100, 200}, []float64{25, 25, 50}},
+		// 10 of 30 and 20 of 30, with the zero and negative sites left out
+		// of the total.
+		{"decommissioned", []int{10, 0, 20, -5}, []float64{100.0 / 3, 0, 200.0 / 3, 0}},
+		{"no capacity", []int{0, 0}, []float64{0, 0}},
+		{"empty", nil, []float64{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := capacitySharesPct(tt.caps)
+			if !slices.EqualFunc(got, tt.want, near) {
+				t.Errorf("capacitySharesPct(%v) = %v, want %v", tt.caps, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadPercentiles(t *testing.T) {
+	// Fullness 0.1 to 1.0 in steps of 0.1, out of order, plus a
+	// decommissioned site that's left out.
+	var stats []SiteStat
+	for _, stored := range []int{7, 2, 10, 5, 1, 9, 3, 8, 4, 6} {
+		stats = append(stats, SiteStat{Capacity: 10, Stored: stored})
+	}
+	stats = append(stats, SiteStat{Capacity: 0})
+	got := loadPercentiles(stats, 0, 50, 90, 99, 100)
+	// The p'th percentile is at rank p/100 * 9 among the sorted ratios.
+	want := map[float64]float64{0: 0.1, 50: 0.55, 90: 0.91, 99: 0.991, 100: 1}
+	for p, w := range want {
+		if !near(got[p], w) {
+			t.Errorf("p%v = %v, want %v", p, got[p], w)
+		}
+	}
+	if got := loadPercentiles(nil, 50); len(got) != 0 {
+		t.Errorf("loadPercentiles(nil) = %v, want none", got)
+	}
+}
+
+func TestHotspotFactor(t *testing.T) {
+	tests := []struct {
+		name    string
+		stats   []SiteStat
+		worstID int
+		factor  float64
+	}{
+		{"empty", nil, 0, 0},
+		{"nothing stored", []SiteStat{{ID: 1, Capacity: 10}, {ID: 2, Capacity: 10}}, 1, 0},
+		{"even", []SiteStat{{ID: 1, Capacity: 10, Stored: 5}, {ID: 2, Capacity: 20, Stored: 10}}, 1, 1},
+		// Ratios 0.1, 0.1, 0.1 and 0.9 have mean 0.3, so site 4 is three
+		// times as full as the mean.
+		{"skewed", []SiteStat{{ID: 1, Capacity: 10, Stored: 1}, {ID: 2, Capacity: 10, Stored: 1}, {ID: 3, Capacity: 10, Stored: 1}, {ID: 4, Capacity: 10, Stored: 9}}, 4, 3},
+		{"decommissioned left out", []SiteStat{{ID: 1, Capacity: 10, Stored: 2}, {ID: 2, Capacity: 0, Stored: 5}, {ID: 3, Capacity: 10, Stored: 6}}, 3, 1.5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			worstID, factor := hotspotFactor(tt.stats)
+			if worstID != tt.worstID || !near(factor, tt.factor) {
+				t.Errorf("hotspotFactor() = %d, %v, want %d, %v", worstID, factor, tt.worstID, tt.factor)
+			}
+		})
+	}
+}
+
+// writeKeys runs the write phase for keys, without reads, on a fresh ring of
+// sites with caps.
+func writeKeys(t testing.TB, keys []string, caps ...int) placer {
+	ring, err := newPlacer("rendezvous", hashing.NewSeeded(1, hashing.FNV{}), newSites(caps))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := newSimulation(ring).run(keys, nil); err != nil {
+		t.Fatal(err)
+	}
+	return ring
+}
+
+// seqKeys returns the keys "0" to n-1.
+func seqKeys(n int) []string {
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+	}
+	return keys
+}
+
+// TestParallelWritesMatchSerial places writes across goroutines, which
+// `go test -race` checks for races, and expects them stored exactly as
+// serial writes are.
+func TestParallelWritesMatchSerial(t *testing.T) {
+	setFlag(t, "rf", "2")
+	setFlag(t, "numReads", "0")
+	keys := seqKeys(5000)
+	caps := []int{1000, 2000, 3000, 500}
+	serial := writeKeys(t, keys, caps...)
+	setFlag(t, "workers", "8")
+	parallel := writeKeys(t, keys, caps...)
+	for i, s := range serial.Sites() {
+		p := parallel.Sites()[i]
+		if !slices.Equal(sortedKeys(s), sortedKeys(p)) {
+			t.Errorf("site %d: serial writes stored %d keys, parallel %d, or different ones", s.ID(), s.Stored(), p.Stored())
+		}
+	}
+}
+
+// sortedKeys returns the keys s holds, sorted.
+func sortedKeys(s *hashing.Site) []string {
+	keys := s.Keys()
+	slices.Sort(keys)
+	return keys
+}
+
+func BenchmarkWritePhase(b *testing.B) {
+	keys := seqKeys(20000)
+	for _, w := range []int{1, 4} {
+		b.Run(fmt.Sprintf("workers=%d", w), func(b *testing.B) {
+			defer func(w, rf, reads int) { *workers, *replicationFactor, *numReads = w, rf, reads }(*workers, *replicationFactor, *numReads)
+			*workers, *replicationFactor, *numReads = w, 3, 0
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				writeKeys(b, keys, 10000, 20000, 30000, 10000, 20000, 30000)
+			}
+		})
+	}
+}
+
+// TestRFSweepRejectsUnsweptFlags checks --rfSweep refuses flags runSweep
+// doesn't pass through to its runs, rather than ignoring them.
+func TestRFSweepRejectsUnsweptFlags(t *testing.T) {
+	for _, tt := range []struct{ flag, value string }{
+		{"ttl", "10"},
+		{"pins", "k:1"},
+		{"siteLatencies", "1,2,3"},
+	} {
+		t.Run(tt.flag, func(t *testing.T) {
+			setFlag(t, "rfSweep", "2")
+			setFlag(t, "mixedOps", "100") // for --ttl
+			setFlag(t, tt.flag, tt.value)
+			if err := validateConfig(newSites([]int{10, 10, 10})); err == nil {
+				t.Errorf("validateConfig with --rfSweep and --%s = nil, want an error", tt.flag)
+			}
+		})
+	}
+}
+
+// TestReplaySkipsOverlongLines checks a line too long to read is counted as
+// malformed and the replay carries on past it.
+func TestReplaySkipsOverlongLines(t *testing.T) {
+	setFlag(t, "rf", "1")
+	sim := newSimulation(newTestRing(t, 100, 100))
+	trace := "W a\nW " + strings.Repeat("x", 2*maxReplayLine) + "\nW b\nbogus\nR a"
+	if err := sim.runReplay(strings.NewReader(trace)); err != nil {
+		t.Fatal(err)
+	}
+	res := sim.result()
+	if res.Writes != 2 || res.Reads != 1 || res.Malformed != 2 {
+		t.Errorf("replay made %d writes and %d reads with %d malformed lines, want 2, 1 and 2", res.Writes, res.Reads, res.Malformed)
+	}
+}
+
+func TestParsePins(t *testing.T) {
+	sites := newSites([]int{10, 10, 10})
+	sites[1].SetName("b")
+	tests := []struct {
+		in      string
+		want    map[string]int
+		wantErr bool
+	}{
+		{in: "k:1", want: map[string]int{"k": 1}},
+		{in: "k:b, j:3", want: map[string]int{"k": 2, "j": 3}},
+		{in: "a:b:1", want: map[string]int{"a:b": 1}},
+		{in: "5:", wantErr: true},
+		{in: "5: ", wantErr: true},
+		{in: ":1", wantErr: true},
+		{in: "k", wantErr: true},
+		{in: "k:4", wantErr: true},
+		{in: "k:1,k:2", wantErr: true},
+	}
+	for _, tt := range tests {
+		pinned, err := parsePins(tt.in, sites)
+		got := make(map[string]int)
+		for key, s := range pinned {
+			got[key] = s.ID()
+		}
+		if (err != nil) != tt.wantErr || (!tt.wantErr && !maps.Equal(got, tt.want)) {
+			t.Errorf("parsePins(%q) = %v, %v, want %v, error %t", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestPlacementBenchmarksNeedKeys(t *testing.T) {
+	for _, tt := range []struct{ flag, value string }{
+		{"throughput", "1s"},
+		{"bench", "true"},
+	} {
+		t.Run(tt.flag, func(t *testing.T) {
+			setFlag(t, tt.flag, tt.value)
+			setFlag(t, "numWrites", "0")
+			if err := validateConfig(newSites([]int{10, 10})); err == nil {
+				t.Errorf("validateConfig with --%s and --numWrites 0 = nil, want an error", tt.flag)
+			}
+			setFlag(t, "numWrites", "10")
+			if err := validateConfig(newSites([]int{10, 10})); err != nil {
+				t.Errorf("validateConfig with --%s and --numWrites 10 = %v, want nil", tt.flag, err)
+			}
+		})
+	}
+}
+
+func TestReadsNeedKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		flags   [][2]string
+		wantErr bool
+	}{
+		{"no writes", [][2]string{{"numWrites", "0"}}, true},
+		{"no writes heatmap", [][2]string{{"numWrites", "0"}, {"output", "heatmap"}}, true},
+		{"no writes prometheus", [][2]string{{"numWrites", "0"}, {"output", "prometheus"}}, true},
+		{"negative writes", [][2]string{{"numWrites", "-1"}, {"numReads", "0"}}, true},
+		{"no writes or reads", [][2]string{{"numWrites", "0"}, {"numReads", "0"}}, false},
+		{"no writes with repl", [][2]string{{"numWrites", "0"}, {"repl", "true"}}, false},
+		{"no writes with replay", [][2]string{{"numWrites", "0"}, {"replay", "true"}}, false},
+		{"mixed ops", [][2]string{{"numWrites", "0"}, {"mixedOps", "100"}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, f := range tt.flags {
+				setFlag(t, f[0], f[1])
+			}
+			err := validateConfig(newSites([]int{10, 10}))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateConfig() = %v, want error %t", err, tt.wantErr)
+			}
+			if err != nil && exitCode(err) != exitBadConfig {
+				t.Errorf("validateConfig() exits %d, want %d", exitCode(err), exitBadConfig)
+			}
+		})
+	}
+}
+
+// TestUnknownOptionsAreFlagErrors checks an unknown value for a flag with a
+// fixed set of options exits as a bad flag would, not as a bad config.
+func TestUnknownOptionsAreFlagErrors(t *testing.T) {
+	for _, name := range []string{"allowZeroCap", "writeStrategy", "replicaPlacement", "onFull", "output"} {
+		t.Run(name, func(t *testing.T) {
+			setFlag(t, name, "bogus")
+			err := validateConfig(newSites([]int{10, 10}))
+			if got := exitCode(err); got != exitBadFlags {
+				t.Errorf("validateConfig with --%s bogus = %v, exit code %d, want %d", name, err, got, exitBadFlags)
+			}
+		})
+	}
+}
+
+// TestJSONOutputRoundTrips checks --output json unmarshals back into what was
+// written, both as a jsonResult and through --baseline.
+func TestJSONOutputRoundTrips(t *testing.T) {
+	setFlag(t, "rf", "2")
+	sim := newSimulation(newTestRing(t, 10, 20, 30))
+	for i := 0; i < 40; i++ {
+		sim.write(strconv.Itoa(i))
+	}
+	for i := 0; i < 50; i++ {
+		sim.read(strconv.Itoa(i))
+	}
+	res := sim.result()
+
+	var buf bytes.Buffer
+	if err := printJSON(&buf, res); err != nil {
+		t.Fatal(err)
+	}
+	var got jsonResult
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.SchemaVersion != jsonSchemaVersion {
+		t.Errorf("schemaVersion = %d, want %d", got.SchemaVersion, jsonSchemaVersion)
+	}
+	if want := currentConfig(); !reflect.DeepEqual(got.Params, want) {
+		t.Errorf("params = %+v, want %+v", got.Params, want)
+	}
+	got.Summary.Sites = got.Sites
+	if !reflect.DeepEqual(got.Summary, res) {
+		t.Errorf("round trip = %+v, want %+v", got.Summary, res)
+	}
+
+	path := filepath.Join(t.TempDir(), "out.json")
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	base, err := loadBaseline(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(base, res) {
+		t.Errorf("loadBaseline = %+v, want %+v", base, res)
+	}
+}
+
+func TestEffectiveConfig(t *testing.T) {
+	str := func(s string) *string { return &s }
+	seed := int64(7)
+	tests := []struct {
+		name     string
+		hash     string
+		seeded   bool
+		wantHash string
+	}{
+		{"unseeded maphash", "maphash", false, "maphash"},
+		{"unseeded fnv", "fnv", false, "fnv"},
+		// Seeding replaces maphash with fnv, so the rerun must ask for fnv.
+		{"seeded maphash", "maphash", true, "fnv"},
+		{"seeded crc64", "crc64", true, "crc64"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := effectiveConfig(Config{Hash: str(tt.hash), Seed: &seed}, tt.seeded)
+			if *got.Hash != tt.wantHash {
+				t.Errorf("hash = %s, want %s", *got.Hash, tt.wantHash)
+			}
+			// Recording a seed for an unseeded run would seed its rerun.
+			if tt.seeded != (got.Seed != nil) {
+				t.Errorf("seed = %v, want one recorded %t", got.Seed, tt.seeded)
+			}
+		})
+	}
+}
+
+func TestREPLJumpRemovesOnlyLastSite(t *testing.T) {
+	setFlag(t, "algo", "jump")
+	setFlag(t, "rf", "1")
+	ring, err := newPlacer("jump", hashing.FNV{}, newSites([]int{10, 10, 10}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sim := newSimulation(ring)
+	var out strings.Builder
+	err = sim.replCommand(&out, "rmsite", []string{"1"})
+	if err == nil || !strings.Contains(err.Error(), "only remove the last site, 3") {
+		t.Errorf("rmsite 1 = %v, want an error saying only site 3 can be removed", err)
+	}
+	if err := sim.replCommand(&out, "rmsite", []string{"9"}); err == nil || !strings.Contains(err.Error(), "no site with id 9") {
+		t.Errorf("rmsite 9 = %v, want no site with id 9", err)
+	}
+	if err := sim.replCommand(&out, "rmsite", []string{"3"}); err != nil {
+		t.Errorf("rmsite 3 = %v, want nil", err)
+	}
+}
+
+func TestHopHistogram(t *testing.T) {
+	tests := []struct {
+		name string
+		hops []int
+		want string
+	}{
+		{"empty", nil, "no hits"},
+		{"no hits", []int{0, 0}, "no hits"},
+		{"primary only", []int{5}, "hop 0 (primary): 100.00%"},
+		// 23 of 25 hits at the primary, 1 each at hops 1 and 2.
+		{"mixed", []int{23, 1, 1}, "hop 0 (primary): 92.00%, hop 1: 4.00%, hop 2: 4.00%"},
+		{"none at primary", []int{0, 3, 1}, "hop 0 (primary): 0.00%, hop 1: 75.00%, hop 2: 25.00%"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hopHistogram(tt.hops); got != tt.want {
+				t.Errorf("hopHistogram(%v) = %q, want %q", tt.hops, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestStaticRingsRejectCapacityChanges checks the algorithms that weight sites
+// only when building the ring refuse flags that change capacities mid-run.
+func TestStaticRingsRejectCapacityChanges(t *testing.T) {
+	for _, algo := range []string{"consistent", "maglev"} {
+		for _, f := range [][2]string{{"scaleSite", "1"}, {"decaySite", "1"}} {
+			t.Run(algo+" "+f[0], func(t *testing.T) {
+				setFlag(t, "algo", algo)
+				setFlag(t, f[0], f[1])
+				setFlag(t, "scaleTo", "100")
+				setFlag(t, "decayRate", "0.5")
+				if err := validateConfig(newSites([]int{10, 10, 10})); err == nil || !strings.Contains(err.Error(), "--algo "+algo) {
+					t.Errorf("validateConfig with --algo %s and --%s = %v, want an error about --algo %s", algo, f[0], err, algo)
+				}
+			})
+		}
+	}
+}
+
+func TestParseSiteNames(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []string
+		wantErr bool
+	}{
+		{in: "a, b ,c", want: []string{"a", "b", "c"}},
+		{in: "us-east-1,eu-2,ap1", want: []string{"us-east-1", "eu-2", "ap1"}},
+		{in: "a,b", wantErr: true},
+		{in: "a,,c", wantErr: true},
+		{in: "a,a,c", wantErr: true},
+		// Integer names could be taken for another site's id.
+		{in: "3,2,1", wantErr: true},
+		{in: "a,+2,c", wantErr: true},
+		{in: "a,007,c", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseSiteNames(tt.in, 3)
+		if (err != nil) != tt.wantErr || !slices.Equal(got, tt.want) {
+			t.Errorf("parseSiteNames(%q, 3) = %q, %v, want %q, error %t", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestDisruption(t *testing.T) {
+	keys := seqKeys(2000)
+	for _, tt := range []struct {
+		algo string
+		ids  []int
+	}{
+		{"rendezvous", []int{1, 2, 3}},
+		// Jump hashing can only remove the last site.
+		{"jump", []int{3}},
+	} {
+		t.Run(tt.algo, func(t *testing.T) {
+			setFlag(t, "algo", tt.algo)
+			setFlag(t, "rf", "1")
+			hasher := hashing.NewSeeded(1, hashing.FNV{})
+			ring, err := newPlacer(tt.algo, hasher, newSites([]int{100, 100, 100}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			rows, err := runDisruption(ring, hasher, keys)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ids []int
+			for _, r := range rows {
+				ids = append(ids, r.ID)
+				if r.Moved != 0 || !near(r.SharePct, r.AffectedPct) {
+					t.Errorf("removing site %d: %d keys moved, %.2f%% affected against a %.2f%% share, want none moved and equal percentages", r.ID, r.Moved, r.AffectedPct, r.SharePct)
+				}
+			}
+			if !slices.Equal(ids, tt.ids) {
+				t.Errorf("removed sites %v, want %v", ids, tt.ids)
+			}
+		})
+	}
+}
@@ -0,0 +1,576 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"example.com/mod/hashing"
+)
+
+// SiteStat is a site's results at the end of a run.
+type SiteStat struct {
+	ID          int     `json:"id"`
+	Capacity    int     `json:"capacity"`
+	Stored      int     `json:"stored"`
+	FullnessPct float64 `json:"fullnessPct"`
+	ReadHits    int     `json:"readHits"`
+	ReadMisses  int     `json:"readMisses"`
+	Evictions   int     `json:"evictions"`
+	// Name is the site's name, with --siteNames.
+	Name string `json:"name,omitempty"`
+	// Namespaces counts the keys stored by namespace, with
+	// --perNamespaceStats.
+	Namespaces []int `json:"namespaces,omitempty"`
+	// TopKeys are the keys with the most read hits, with --topKeys.
+	TopKeys []hashing.KeyCount `json:"topKeys,omitempty"`
+}
+
+// shareRow is a site's share of capacity, the share of keys it should get,
+// against its share of the key copies stored, with --shares.
+type shareRow struct {
+	ID          int     `json:"id"`
+	ExpectedPct float64 `json:"expectedPct"`
+	ActualPct   float64 `json:"actualPct"`
+	DeltaPct    float64 `json:"deltaPct"`
+}
+
+// expiryResult is how many key copies expired with --ttl, and how many reads
+// missed because the key expired rather than because it was never placed.
+type expiryResult struct {
+	Copies          int `json:"copies"`
+	ExpiryMisses    int `json:"expiryMisses"`
+	PlacementMisses int `json:"placementMisses"`
+}
+
+// rolesResult is how --readOnlySites and --writeOnlySites got in the way:
+// the writes with a read-only site among their top --rf sites, and the
+// write-only sites passed over by reads.
+type rolesResult struct {
+	ReadOnlyBlocks int `json:"readOnlyBlocks"`
+	WriteOnlySkips int `json:"writeOnlySkips"`
+}
+
+// alertRow is when a site first crossed --alertAt fullness: the operation
+// it was on, and how many sites had crossed before it.
+type alertRow struct {
+	ID    int    `json:"id"`
+	Name  string `json:"name,omitempty"`
+	Order int    `json:"order"`
+	Op    int    `json:"op"`
+}
+
+// collisionResult counts the written keys for which two or more sites scored
+// identically, and the pairs of sites that did, with --detectCollisions.
+type collisionResult struct {
+	Keys    int     `json:"keys"`
+	KeysPct float64 `json:"keysPct"`
+	Pairs   int     `json:"pairs"`
+}
+
+// timelinePoint is the sites' fullness partway through a mixed workload.
+type timelinePoint struct {
+	OpsPct int            `json:"opsPct"`
+	Sites  []siteFullness `json:"sites"`
+}
+
+type siteFullness struct {
+	ID          int     `json:"id"`
+	FullnessPct float64 `json:"fullnessPct"`
+}
+
+// scaleResult is the share of stored keys held by the site scaled with
+// --scaleTo, before and after it was scaled.
+type scaleResult struct {
+	ID            int     `json:"id"`
+	From          int     `json:"from"`
+	To            int     `json:"to"`
+	SharePct      float64 `json:"sharePct"`
+	ShareAfterPct float64 `json:"shareAfterPct"`
+	// ShareSincePct is the site's share of the keys stored after scaling.
+	ShareSincePct float64 `json:"shareSincePct"`
+	// Rebalanced is the number of key copies moved by --rebalance.
+	Rebalanced int `json:"rebalanced"`
+
+	storedAt, allStoredAt int
+}
+
+// decayResult is the trajectory of the site whose capacity shrinks with
+// --decaySite.
+type decayResult struct {
+	ID     int          `json:"id"`
+	From   int          `json:"from"`
+	To     int          `json:"to"`
+	Points []decayPoint `json:"points"`
+
+	site                  *hashing.Site
+	storedAt, allStoredAt int
+}
+
+// decayPoint is the --decaySite's capacity and share of stored keys partway
+// through the run.
+type decayPoint struct {
+	OpsPct   int     `json:"opsPct"`
+	Capacity int     `json:"capacity"`
+	SharePct float64 `json:"sharePct"`
+	// SinceSharePct is the site's share of the keys stored since the
+	// previous point.
+	SinceSharePct float64 `json:"sinceSharePct"`
+}
+
+// sampleResult extrapolates a run on --sampleKeys keys to all the keys they
+// were sampled from.
+type sampleResult struct {
+	Keys int `json:"keys"`
+	Of   int `json:"of"`
+	// Copies and UnableToWrite are the sample's scaled up to all keys.
+	Copies           int     `json:"copies"`
+	UnableToWrite    int     `json:"unableToWrite"`
+	UnableToWritePct float64 `json:"unableToWritePct"`
+	// StdErrPct is the standard error of UnableToWritePct from sampling.
+	StdErrPct float64 `json:"stdErrPct"`
+}
+
+// percentile is the P'th percentile of a distribution.
+type percentile struct {
+	P     float64 `json:"p"`
+	Value float64 `json:"value"`
+}
+
+// result is a run's outcome. Bump jsonSchemaVersion when its JSON fields
+// change.
+type result struct {
+	Sites         []SiteStat `json:"sites,omitempty"`
+	Reads         int        `json:"reads"`
+	Writes        int        `json:"writes"`
+	UnableToWrite int        `json:"unableToWrite"`
+	// ShortCircuited counts the writes rejected without being placed,
+	// because no site had capacity left.
+	ShortCircuited int `json:"shortCircuited,omitempty"`
+	// AckFailures counts the writes rejected because fewer than
+	// --writeAck sites could take them.
+	AckFailures    int     `json:"ackFailures,omitempty"`
+	Evictions      int     `json:"evictions"`
+	FailoverReads  int     `json:"failoverReads"`
+	Repairs        int     `json:"repairs"`
+	SkippedRepairs int     `json:"skippedRepairs"`
+	ReadHitPct     float64 `json:"readHitPct"`
+	MeanStored     float64 `json:"meanStored"`
+	StddevStored   float64 `json:"stddevStored"`
+	Gini           float64 `json:"gini"`
+
+	// HotspotSite is the fullest site and HotspotFactor its fullness over the
+	// mean fullness.
+	HotspotSite   int     `json:"hotspotSite"`
+	HotspotFactor float64 `json:"hotspotFactor"`
+
+	// ClassicScorer is how the classic scorer loaded sites over the same
+	// run, with --scoreVariant freecap.
+	ClassicScorer *scorerComparison `json:"classicScorer,omitempty"`
+
+	// DistinctKeys is the number of keys stored on at least one site, Copies
+	// the number of copies of them stored, and CapacityUsedPct the share of
+	// the cluster's capacity the copies use.
+	DistinctKeys    int     `json:"distinctKeys"`
+	Copies          int     `json:"copies"`
+	CapacityUsedPct float64 `json:"capacityUsedPct"`
+
+	// FullnessPercentiles are the p50, p90 and p99 site fullness ratios,
+	// with --percentiles.
+	FullnessPercentiles []percentile `json:"fullnessPercentiles,omitempty"`
+
+	// CopiesFound counts reads by how many of the key's replica sites held
+	// it, from 0 to --rf, with --checkAllReplicas.
+	CopiesFound []int `json:"copiesFound,omitempty"`
+
+	// ReadLatencyPercentiles are the p50, p90 and p99 read latencies in
+	// milliseconds, with --siteLatencies.
+	ReadLatencyPercentiles []percentile `json:"readLatencyPercentiles,omitempty"`
+
+	// Shares are each site's expected and actual share of stored keys, with
+	// --shares.
+	Shares []shareRow `json:"shares,omitempty"`
+
+	Timeline []timelinePoint `json:"timeline,omitempty"`
+	Scale    *scaleResult    `json:"scale,omitempty"`
+	Decay    *decayResult    `json:"decay,omitempty"`
+	Expiry   *expiryResult   `json:"expiry,omitempty"`
+	Sample   *sampleResult   `json:"sample,omitempty"`
+
+	Collisions *collisionResult `json:"collisions,omitempty"`
+	Roles      *rolesResult     `json:"roles,omitempty"`
+	// PinFailures counts the writes of keys pinned with --pins that their
+	// site was too full to take.
+	PinFailures int `json:"pinFailures,omitempty"`
+	// FullReplicas counts writes by how many of their top --rf sites were
+	// full, from 1 up; index 0 is unused.
+	FullReplicas []int `json:"fullReplicas,omitempty"`
+	// Alerts are the sites that crossed --alertAt fullness, in the order
+	// they did.
+	Alerts []alertRow `json:"alerts,omitempty"`
+
+	// ReadHops counts read hits by the position in the key's site ordering
+	// of the site that served them.
+	ReadHops []int `json:"readHops"`
+	// UniformHitPct is the hit rate uniform reads would have seen, with
+	// --readsFollowWrites.
+	UniformHitPct float64 `json:"uniformHitPct,omitempty"`
+	// Deletes counts the keys deleted, with --replay.
+	Deletes int `json:"deletes,omitempty"`
+	// Malformed counts the lines of --replay input skipped because they
+	// weren't operations.
+	Malformed int `json:"malformed,omitempty"`
+	// QuorumFailures counts reads that found the key on fewer than
+	// --readQuorum sites, but not none.
+	QuorumFailures int `json:"quorumFailures,omitempty"`
+	// ZoneFallbacks counts writes whose replica sites couldn't all be in
+	// distinct zones, with --siteZones.
+	ZoneFallbacks int `json:"zoneFallbacks,omitempty"`
+	// Achieved counts writes by the number of replicas placed.
+	Achieved []int `json:"achievedReplicas"`
+	// Heatmap is where a sample of keys is placed, with --output heatmap.
+	Heatmap *heatmap `json:"-"`
+	// Interrupted is set if the run was stopped early, in which case Reads
+	// and Writes are the operations that completed.
+	Interrupted bool `json:"interrupted"`
+}
+
+// siteLabel returns the name of the site with the given id, or the id if it
+// has no name.
+func (res result) siteLabel(id int) string {
+	for _, s := range res.Sites {
+		if s.ID == id {
+			return siteLabel(s.ID, s.Name)
+		}
+	}
+	return strconv.Itoa(id)
+}
+
+// siteLabel returns name, or id if the site has no name, for output.
+func siteLabel(id int, name string) string {
+	if name != "" {
+		return name
+	}
+	return strconv.Itoa(id)
+}
+
+func collectStats(sites []*hashing.Site) []SiteStat {
+	var stats []SiteStat
+	for _, s := range sites {
+		stats = append(stats, SiteStat{
+			ID:          s.ID(),
+			Name:        s.Name(),
+			Capacity:    s.Capacity(),
+			Stored:      s.Stored(),
+			FullnessPct: pct(s.Stored(), s.Capacity()),
+			ReadHits:    s.ReadHits(),
+			ReadMisses:  s.ReadMisses(),
+			Evictions:   s.Evictions(),
+			Namespaces:  namespaceCounts(s),
+			TopKeys:     s.TopKeys(*topKeys),
+		})
+	}
+	return stats
+}
+
+// namespaceCounts returns the number of keys s stores in each namespace, or
+// nil without --perNamespaceStats.
+func namespaceCounts(s *hashing.Site) []int {
+	if !*perNamespaceStats {
+		return nil
+	}
+	counts := make([]int, *namespaces)
+	for _, key := range s.Keys() {
+		counts[keyNamespace(key)]++
+	}
+	return counts
+}
+
+// pct returns n as a percentage of total, or 0 if total isn't positive: a
+// decommissioned site, which has no capacity, is empty, and a run without
+// writes rejected none.
+func pct(n, total int) float64 {
+	if total <= 0 {
+		return 0
+	}
+	return float64(n) / float64(total) * 100
+}
+
+// printers maps each --output format to the function that renders it.
+var printers = map[string]func(w io.Writer, res result) error{
+	"text":       printText,
+	"json":       printJSON,
+	"csv":        printCSV,
+	"prometheus": printPrometheus,
+	"heatmap":    printHeatmap,
+}
+
+// jsonSchemaVersion is the version of --output json's format, bumped whenever
+// its fields change so that tooling can tell what it's parsing. Before
+// versioning, the output was a bare result.
+const jsonSchemaVersion = 1
+
+// jsonResult is what --output json writes: the parameters the run was made
+// with, its sites and the rest of its result.
+type jsonResult struct {
+	SchemaVersion int `json:"schemaVersion"`
+	// Params are the run's parameters, defaults included, in the form
+	// --config reads.
+	Params  Config     `json:"params"`
+	Sites   []SiteStat `json:"sites"`
+	Summary result     `json:"summary"`
+}
+
+func printJSON(w io.Writer, res result) error {
+	out := jsonResult{SchemaVersion: jsonSchemaVersion, Params: currentConfig(), Sites: res.Sites, Summary: res}
+	out.Summary.Sites = nil
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+// printCSV writes one row per site, with a site_name column after site_id
+// with --siteNames. The cluster-wide summary goes to stderr so the CSV body
+// stays clean.
+func printCSV(w io.Writer, res result) error {
+	cw := csv.NewWriter(w)
+	header := []string{"site_id", "capacity", "stored", "fullness_pct", "read_hits", "read_misses"}
+	if *siteNames != "" {
+		header = append(header[:1], append([]string{"site_name"}, header[1:]...)...)
+	}
+	cw.Write(header)
+	for _, s := range res.Sites {
+		row := []string{
+			strconv.Itoa(s.ID),
+			strconv.Itoa(s.Capacity),
+			strconv.Itoa(s.Stored),
+			strconv.FormatFloat(s.FullnessPct, 'f', -1, 64),
+			strconv.Itoa(s.ReadHits),
+			strconv.Itoa(s.ReadMisses),
+		}
+		if *siteNames != "" {
+			row = append(row[:1], append([]string{s.Name}, row[1:]...)...)
+		}
+		cw.Write(row)
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return err
+	}
+	return printSummary(os.Stderr, res)
+}
+
+// promSiteLabels returns the Prometheus labels identifying s: its id, and its
+// name if it has one.
+func promSiteLabels(s SiteStat) string {
+	if s.Name == "" {
+		return fmt.Sprintf("site=%q", strconv.Itoa(s.ID))
+	}
+	return fmt.Sprintf("site=%q,name=%q", strconv.Itoa(s.ID), s.Name)
+}
+
+// printPrometheus writes the results in the Prometheus text exposition format.
+func printPrometheus(w io.Writer, res result) error {
+	siteMetrics := []struct {
+		name, help, typ string
+		value           func(SiteStat) float64
+	}{
+		{"site_fullness", "Fraction of the site's capacity in use.", "gauge", func(s SiteStat) float64 { return s.FullnessPct / 100 }},
+		{"site_stored_keys", "Keys stored on the site.", "gauge", func(s SiteStat) float64 { return float64(s.Stored) }},
+		{"site_read_hits", "Reads served by the site.", "counter", func(s SiteStat) float64 { return float64(s.ReadHits) }},
+		{"site_read_misses", "Reads that didn't find the key on the site.", "counter", func(s SiteStat) float64 { return float64(s.ReadMisses) }},
+	}
+	for _, m := range siteMetrics {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.typ)
+		for _, s := range res.Sites {
+			fmt.Fprintf(w, "%s{%s} %s\n", m.name, promSiteLabels(s), strconv.FormatFloat(m.value(s), 'g', -1, 64))
+		}
+	}
+	fmt.Fprintf(w, "# HELP cluster_unable_to_write_total Writes rejected because a replica site couldn't take them.\n# TYPE cluster_unable_to_write_total counter\n")
+	_, err := fmt.Fprintf(w, "cluster_unable_to_write_total %d\n", res.UnableToWrite)
+	return err
+}
+
+// printText writes a line per site, unless --quiet, then the summary.
+func printText(w io.Writer, res result) error {
+	if *quiet {
+		return printSummary(w, res)
+	}
+	for _, s := range res.Sites {
+		fmt.Fprintf(w, "site %s: %d/%d (%.2f%% full)", res.siteLabel(s.ID), s.Stored, s.Capacity, s.FullnessPct)
+		if res.Reads == 0 {
+			fmt.Fprintln(w)
+		} else {
+			fmt.Fprintf(w, ". received reads: %d hits (%.2f%% of total), %d misses\n", s.ReadHits, float64(s.ReadHits)/float64(res.Reads)*100, s.ReadMisses)
+		}
+		if len(s.TopKeys) > 0 {
+			fmt.Fprint(w, "  top keys:")
+			for _, k := range s.TopKeys {
+				fmt.Fprintf(w, " %s (%d)", k.Key, k.Count)
+			}
+			fmt.Fprintln(w)
+		}
+		if len(s.Namespaces) > 0 {
+			fmt.Fprint(w, "  by namespace:")
+			for ns, n := range s.Namespaces {
+				fmt.Fprintf(w, " %d: %.2f%%", ns, pct(n, s.Stored))
+			}
+			fmt.Fprintln(w)
+		}
+	}
+	return printSummary(w, res)
+}
+
+// printSummary writes the cluster-wide results as text.
+func printSummary(w io.Writer, res result) error {
+	for _, p := range res.Timeline {
+		fmt.Fprintf(w, "fullness %d%% through:", p.OpsPct)
+		for _, s := range p.Sites {
+			fmt.Fprintf(w, " site %s %.2f%%", res.siteLabel(s.ID), s.FullnessPct)
+		}
+		fmt.Fprintln(w)
+	}
+	if res.Interrupted {
+		fmt.Fprintf(w, "interrupted: stats are partial, covering the %d writes and %d reads that completed\n", res.Writes, res.Reads)
+	}
+	fmt.Fprintf(w, "unable to write: %d (%.2f%%)\n", res.UnableToWrite, pct(res.UnableToWrite, res.Writes))
+	if res.ShortCircuited > 0 {
+		fmt.Fprintf(w, "writes rejected without placing them, once no site had capacity left: %d\n", res.ShortCircuited)
+	}
+	if *writeAck > 1 {
+		fmt.Fprintf(w, "writes rejected with fewer than %d of %d replicas acknowledged: %d\n", *writeAck, *replicationFactor, res.AckFailures)
+	}
+	fmt.Fprintf(w, "stored: %d distinct keys as %d copies (%.2f per key), using %.2f%% of cluster capacity\n", res.DistinctKeys, res.Copies, float64(res.Copies)/float64(max(res.DistinctKeys, 1)), res.CapacityUsedPct)
+	fmt.Fprintf(w, "load: mean %.2f keys, stddev %.2f keys, gini %.4f\n", res.MeanStored, res.StddevStored, res.Gini)
+	_, err := fmt.Fprintf(w, "hotspot: site %s at %.2fx the mean fullness\n", res.siteLabel(res.HotspotSite), res.HotspotFactor)
+	if c := res.ClassicScorer; c != nil {
+		_, err = fmt.Fprintf(w, "vs classic scorer: stddev %.2f keys (%+.2f), gini %.4f (%+.4f), hotspot %.2fx (%+.2f), unable to write %d (%+d)\n", c.StddevStored, res.StddevStored-c.StddevStored, c.Gini, res.Gini-c.Gini, c.HotspotFactor, res.HotspotFactor-c.HotspotFactor, c.UnableToWrite, res.UnableToWrite-c.UnableToWrite)
+	}
+	if len(res.FullnessPercentiles) > 0 {
+		fmt.Fprint(w, "fullness percentiles:")
+		for _, p := range res.FullnessPercentiles {
+			fmt.Fprintf(w, " p%g %.2f%%", p.P, p.Value*100)
+		}
+		_, err = fmt.Fprintln(w)
+	}
+	for _, sh := range res.Shares {
+		_, err = fmt.Fprintf(w, "share: site %s expected %.2f%%, actual %.2f%% (%+.2f)\n", res.siteLabel(sh.ID), sh.ExpectedPct, sh.ActualPct, sh.DeltaPct)
+	}
+	if len(res.FullReplicas) > 1 {
+		fmt.Fprintf(w, "writes finding full sites among their top %d:", *replicationFactor)
+		for n, writes := range res.FullReplicas[1:] {
+			fmt.Fprintf(w, " %d full: %d", n+1, writes)
+		}
+		_, err = fmt.Fprintln(w)
+	}
+	if *writeStrategy != "all" {
+		fmt.Fprint(w, "achieved replicas:")
+		for n, writes := range res.Achieved {
+			fmt.Fprintf(w, " %d: %d", n, writes)
+		}
+		_, err = fmt.Fprintln(w)
+	}
+	if *replay {
+		_, err = fmt.Fprintf(w, "replay: %d deletes, %d malformed lines skipped\n", res.Deletes, res.Malformed)
+	}
+	if *readQuorum > 1 {
+		_, err = fmt.Fprintf(w, "read quorum of %d: hit rate %.2f%%, %d reads found the key on too few sites\n", *readQuorum, res.ReadHitPct, res.QuorumFailures)
+	}
+	if *siteZones != "" {
+		_, err = fmt.Fprintf(w, "writes unable to spread replicas across %d zones: %d (%.2f%%)\n", *replicationFactor, res.ZoneFallbacks, pct(res.ZoneFallbacks, res.Writes))
+	}
+	if *readsFollowWrites {
+		_, err = fmt.Fprintf(w, "read hit rate: %.2f%%, against %.2f%% for uniform reads of the written keys\n", res.ReadHitPct, res.UniformHitPct)
+	}
+	if *burnIn > 0 {
+		_, err = fmt.Fprintf(w, "read stats leave out the first %d reads as burn-in\n", *burnIn)
+	}
+	if len(res.ReadLatencyPercentiles) > 0 {
+		fmt.Fprint(w, "read latency:")
+		for _, p := range res.ReadLatencyPercentiles {
+			fmt.Fprintf(w, " p%g %.2fms", p.P, p.Value)
+		}
+		_, err = fmt.Fprintln(w)
+	}
+	if len(res.CopiesFound) > 0 {
+		fmt.Fprint(w, "copies found per read:")
+		for n, reads := range res.CopiesFound {
+			fmt.Fprintf(w, " %d: %d", n, reads)
+		}
+		_, err = fmt.Fprintln(w)
+	}
+	if len(res.ReadHops) > 0 {
+		_, err = fmt.Fprintf(w, "read hops: %s\n", hopHistogram(res.ReadHops))
+	}
+	if *onFull == "evict" {
+		_, err = fmt.Fprintf(w, "evictions: %d\n", res.Evictions)
+	}
+	if *failSites != "" {
+		_, err = fmt.Fprintf(w, "reads served by a replica while the primary was down: %d\n", res.FailoverReads)
+	}
+	if sc := res.Scale; sc != nil {
+		_, err = fmt.Fprintf(w, "site %s scaled from %d to %d: held %.2f%% of stored keys before, %.2f%% after, and %.2f%% of those stored since\n", res.siteLabel(sc.ID), sc.From, sc.To, sc.SharePct, sc.ShareAfterPct, sc.ShareSincePct)
+		if *rebalance {
+			_, err = fmt.Fprintf(w, "rebalance moved %d key copies\n", sc.Rebalanced)
+		}
+	}
+	if sm := res.Sample; sm != nil {
+		_, err = fmt.Fprintf(w, "sampled %d of %d keys on sites with capacities scaled to match; extrapolated: %d copies stored, %d unable to write (%.2f%% ± %.2f%% standard error)\n", sm.Keys, sm.Of, sm.Copies, sm.UnableToWrite, sm.UnableToWritePct, sm.StdErrPct)
+	}
+	if d := res.Decay; d != nil {
+		_, err = fmt.Fprintf(w, "site %s decayed from %d to %d:\n", res.siteLabel(d.ID), d.From, d.To)
+		for _, p := range d.Points {
+			_, err = fmt.Fprintf(w, "  %d%% through: capacity %d, holding %.2f%% of stored keys and %.2f%% of those stored since the last point\n", p.OpsPct, p.Capacity, p.SharePct, p.SinceSharePct)
+		}
+	}
+	if *pins != "" {
+		_, err = fmt.Fprintf(w, "writes of pinned keys their full pinned site couldn't take: %d\n", res.PinFailures)
+	}
+	if *alertAt > 0 {
+		_, err = fmt.Fprintf(w, "sites crossing %.0f%% full: %d\n", *alertAt*100, len(res.Alerts))
+		for _, a := range res.Alerts {
+			_, err = fmt.Fprintf(w, "  %d. site %s at op %d\n", a.Order, siteLabel(a.ID, a.Name), a.Op)
+		}
+	}
+	if r := res.Roles; r != nil {
+		_, err = fmt.Fprintf(w, "site roles: %d writes (%.2f%%) had a read-only site among their top %d, reads passed over write-only sites %d times\n", r.ReadOnlyBlocks, pct(r.ReadOnlyBlocks, res.Writes), *replicationFactor, r.WriteOnlySkips)
+	}
+	if c := res.Collisions; c != nil {
+		_, err = fmt.Fprintf(w, "score collisions: %d written keys (%.4f%%) had sites scoring identically, %d pairs of sites in all; if nonzero, try another --hash\n", c.Keys, c.KeysPct, c.Pairs)
+	}
+	if e := res.Expiry; e != nil {
+		_, err = fmt.Fprintf(w, "expiry: %d key copies expired; read misses: %d of expired keys, %d of keys never placed\n", e.Copies, e.ExpiryMisses, e.PlacementMisses)
+	}
+	if *readRepair {
+		_, err = fmt.Fprintf(w, "read repairs: %d, skipped because the site was full: %d\n", res.Repairs, res.SkippedRepairs)
+	}
+	return err
+}
+
+// hopHistogram renders the share of read hits served at each position in the
+// site ordering, e.g. "hop 0 (primary): 92.00%, hop 1: 8.00%".
+func hopHistogram(hops []int) string {
+	var total int
+	for _, n := range hops {
+		total += n
+	}
+	if total == 0 {
+		return "no hits"
+	}
+	var b strings.Builder
+	for i, n := range hops {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		fmt.Fprintf(&b, "hop %d", i)
+		if i == 0 {
+			b.WriteString(" (primary)")
+		}
+		fmt.Fprintf(&b, ": %.2f%%", float64(n)/float64(total)*100)
+	}
+	return b.String()
+}
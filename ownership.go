@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// ownershipRow is how a site's share of primary placements compares to its
+// share of capacity.
+type ownershipRow struct {
+	ID          int     `json:"id"`
+	Name        string  `json:"name,omitempty"`
+	Keys        int     `json:"keys"`
+	SharePct    float64 `json:"sharePct"`
+	ExpectedPct float64 `json:"expectedPct"`
+}
+
+// runOwnership places n dense synthetic keys on ring, without storing them,
+// and returns the fraction of them each site is primary for, against the
+// fraction its capacity should win it, or an equal share if sites aren't
+// weighted. Decommissioned sites are left out.
+func runOwnership(ring placer, n int) []ownershipRow {
+	counts := make(map[int]int)
+	for i := 0; i < n; i++ {
+		if top := ring.TopSites(syntheticKey(i, i), 1); len(top) > 0 {
+			counts[top[0].ID()]++
+		}
+	}
+
+	var total float64
+	var active int
+	for _, s := range ring.Sites() {
+		if s.Capacity() > 0 {
+			total += float64(s.Capacity())
+			active++
+		}
+	}
+	var rows []ownershipRow
+	for _, s := range ring.Sites() {
+		if s.Capacity() <= 0 {
+			continue
+		}
+		p := float64(s.Capacity()) / total
+		if !*weighted || *algo == "jump" {
+			p = 1 / float64(active)
+		}
+		row := ownershipRow{ID: s.ID(), Name: s.Name(), Keys: counts[s.ID()], ExpectedPct: p * 100}
+		if n > 0 {
+			row.SharePct = float64(row.Keys) / float64(n) * 100
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// printOwnership writes rows as a table, or as JSON with --output json.
+func printOwnership(w io.Writer, rows []ownershipRow, n int) error {
+	if *output == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(rows)
+	}
+	fmt.Fprintf(w, "primary ownership of %d sampled keys:\n", n)
+	fmt.Fprintf(w, "%-10s %-10s %-10s %s\n", "site", "owned", "expected", "delta")
+	var err error
+	for _, r := range rows {
+		_, err = fmt.Fprintf(w, "%-10s %-10s %-10s %+.4f%%\n", siteLabel(r.ID, r.Name), fmt.Sprintf("%.4f%%", r.SharePct), fmt.Sprintf("%.4f%%", r.ExpectedPct), r.SharePct-r.ExpectedPct)
+	}
+	return err
+}
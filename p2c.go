@@ -0,0 +1,72 @@
+package main
+
+import (
+	"math/rand"
+	"time"
+
+	"example.com/mod/hashing"
+)
+
+// p2c places writes by the power of two choices, with --writeStrategy p2c:
+// each replica goes on the less full of two sites sampled in proportion to
+// capacity. Placement isn't a function of the key, so where each key went is
+// remembered for reads to find it.
+type p2c struct {
+	rng    *rand.Rand
+	placed map[string][]*hashing.Site
+}
+
+// newP2C returns a p2c whose sampling is reproducible under --seed.
+func newP2C() *p2c {
+	rngSeed := time.Now().UnixNano()
+	if flagSet("seed") {
+		rngSeed = *seed
+	}
+	return &p2c{rng: rand.New(rand.NewSource(rngSeed)), placed: make(map[string][]*hashing.Site)}
+}
+
+// choose returns up to replicationFactor distinct sites for a new key, each the
+// less full of two sampled from the sites that could take a write and haven't
+// been chosen already.
+func (p *p2c) choose(sim *simulation) []*hashing.Site {
+	var eligible []*hashing.Site
+	for _, s := range sim.ring.Sites() {
+		if s.Online() && !s.ReadOnly() && s.Capacity() > 0 {
+			eligible = append(eligible, s)
+		}
+	}
+	var chosen []*hashing.Site
+	for len(chosen) < *replicationFactor && len(eligible) > 0 {
+		a := p.sample(eligible, -1)
+		best := a
+		if len(eligible) > 1 {
+			if b := p.sample(eligible, a); sim.fullness(eligible[b]) < sim.fullness(eligible[a]) {
+				best = b
+			}
+		}
+		chosen = append(chosen, eligible[best])
+		eligible = append(eligible[:best], eligible[best+1:]...)
+	}
+	return chosen
+}
+
+// sample returns the index of one of sites, other than skip, drawn in
+// proportion to capacity.
+func (p *p2c) sample(sites []*hashing.Site, skip int) int {
+	var total int
+	for i, s := range sites {
+		if i != skip {
+			total += s.Capacity()
+		}
+	}
+	n := p.rng.Intn(total)
+	for i, s := range sites {
+		if i == skip {
+			continue
+		}
+		if n -= s.Capacity(); n < 0 {
+			return i
+		}
+	}
+	return len(sites) - 1
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+
+	"example.com/mod/hashing"
+)
+
+const replUsage = `commands:
+  place <key> [rf]   show the ids of key's top rf sites, rf defaulting to --rf
+  write <key>        write key per --writeStrategy
+  read <key>         read key, reporting a hit or miss
+  stats              show each site's stats and the cluster's
+  addsite <capacity> add a site with the next free id
+  rmsite <id>        remove a site, rewriting its keys onto their new sites
+  help               show this
+  quit               stop
+`
+
+// runREPL reads commands from in, one per line, and applies them to a
+// simulation on ring, writing the outcome of each to out. It's --serve's
+// placements, plus writes, reads and membership changes, at a prompt.
+func runREPL(ring placer, in io.Reader, out io.Writer) error {
+	sim := newSimulation(ring)
+	scanner := bufio.NewScanner(in)
+	for {
+		fmt.Fprint(out, "> ")
+		if !scanner.Scan() {
+			fmt.Fprintln(out)
+			return scanner.Err()
+		}
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "quit" || fields[0] == "exit" {
+			return nil
+		}
+		if err := sim.replCommand(out, fields[0], fields[1:]); err != nil {
+			fmt.Fprintf(out, "error: %v\n", err)
+		}
+	}
+}
+
+// replCommand applies one REPL command with the given arguments.
+func (sim *simulation) replCommand(out io.Writer, cmd string, args []string) error {
+	ring := sim.ring
+	// nargs is the least and most arguments each command takes.
+	nargs := map[string][2]int{"place": {1, 2}, "write": {1, 1}, "read": {1, 1}, "stats": {0, 0}, "addsite": {1, 1}, "rmsite": {1, 1}}
+	if n, ok := nargs[cmd]; ok && (len(args) < n[0] || len(args) > n[1]) {
+		return fmt.Errorf("wrong number of arguments to %s; type help for usage", cmd)
+	}
+	switch cmd {
+	case "place":
+		rf := *replicationFactor
+		if len(args) == 2 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 || n > len(ring.Sites()) {
+				return fmt.Errorf("invalid rf %q: want between 1 and %d", args[1], len(ring.Sites()))
+			}
+			rf = n
+		}
+		fmt.Fprintf(out, "%s: sites %s\n", args[0], siteLabels(ring.TopSites(args[0], rf)))
+	case "write":
+		sim.write(args[0])
+		if _, ok := sim.unableToWrite[args[0]]; ok {
+			fmt.Fprintf(out, "%s: unable to write: %s\n", args[0], sim.refusal(args[0], sim.candidates(args[0])))
+			break
+		}
+		var held []*hashing.Site
+		for _, s := range ring.Sites() {
+			if s.Has(args[0]) {
+				held = append(held, s)
+			}
+		}
+		fmt.Fprintf(out, "%s: stored on sites %s\n", args[0], siteLabels(held))
+	case "read":
+		hits := sim.quorumHits
+		sim.read(args[0])
+		if sim.quorumHits > hits {
+			fmt.Fprintf(out, "%s: hit\n", args[0])
+		} else {
+			fmt.Fprintf(out, "%s: miss\n", args[0])
+		}
+	case "stats":
+		return printText(out, sim.result())
+	case "addsite":
+		capacity, err := strconv.Atoi(args[0])
+		if err != nil || capacity < 0 {
+			return fmt.Errorf("invalid capacity %q", args[0])
+		}
+		s := hashing.NewSite(nextSiteID(ring.Sites()), capacity)
+		ring.AddSite(s)
+		fmt.Fprintf(out, "added site %s\n", s.Label())
+	case "rmsite":
+		id, err := strconv.Atoi(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid site id %q", args[0])
+		}
+		if len(ring.Sites()) <= *replicationFactor {
+			return fmt.Errorf("can't remove a site: replication factor %d needs at least %d sites", *replicationFactor, *replicationFactor)
+		}
+		removed := ring.RemoveSite(id)
+		if removed == nil {
+			sites := ring.Sites()
+			if *algo == "jump" && slices.ContainsFunc(sites, func(s *hashing.Site) bool { return s.ID() == id }) {
+				return fmt.Errorf("--algo jump can only remove the last site, %s", sites[len(sites)-1].Label())
+			}
+			return fmt.Errorf("no site with id %d", id)
+		}
+		for _, key := range removed.Keys() {
+			for _, s := range ring.TopSites(key, *replicationFactor) {
+				if !s.Has(key) && !s.Full() {
+					s.HandleWrite(key)
+				}
+			}
+		}
+		fmt.Fprintf(out, "removed site %s, rewriting its %d keys\n", removed.Label(), len(removed.Keys()))
+	default:
+		if cmd != "help" {
+			fmt.Fprintf(out, "unknown command %q\n", cmd)
+		}
+		fmt.Fprint(out, replUsage)
+	}
+	return nil
+}
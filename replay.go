@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// maxReplayLine is the longest line --replay reads. Longer lines are counted
+// as malformed.
+const maxReplayLine = 64 << 10
+
+// runReplay applies the operations read from r in order until EOF: each line
+// is "W <key>" to write key, "R <key>" to read it or "D <key>" to delete it.
+// Blank lines are skipped, and malformed lines are counted and skipped rather
+// than ending the replay.
+func (sim *simulation) runReplay(r io.Reader) error {
+	br := bufio.NewReaderSize(r, maxReplayLine)
+	for line := 0; ; line++ {
+		text, overlong, err := readLine(br)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("reading --replay operations: %v", err)
+		}
+		if sim.stopped() {
+			return nil
+		}
+		sim.op = line
+		sim.progress("lines", line, 0)
+		if overlong {
+			sim.malformed++
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			sim.malformed++
+			continue
+		}
+		switch key := fields[1]; fields[0] {
+		case "W":
+			sim.write(key)
+		case "R":
+			if sim.tally == nil {
+				sim.read(key)
+			}
+		case "D":
+			sim.delete(key)
+		default:
+			sim.malformed++
+		}
+	}
+	sim.writesDone()
+	return nil
+}
+
+// readLine returns the next line from br without its line ending. A line
+// longer than br's buffer is discarded, and reported as overlong.
+func readLine(br *bufio.Reader) (line string, overlong bool, err error) {
+	b, isPrefix, err := br.ReadLine()
+	if err != nil {
+		return "", false, err
+	}
+	line = string(b)
+	for isPrefix {
+		overlong = true
+		if _, isPrefix, err = br.ReadLine(); err != nil && err != io.EOF {
+			return "", true, err
+		}
+	}
+	return line, overlong, nil
+}
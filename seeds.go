@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+
+	"example.com/mod/hashing"
+)
+
+// seedRun is how evenly keys were placed under one hash seed.
+type seedRun struct {
+	Seed          int64   `json:"seed"`
+	HotspotSite   int     `json:"hotspotSite"`
+	HotspotFactor float64 `json:"hotspotFactor"`
+}
+
+// seedSiteLoad is how a site's load varied across seeds.
+type seedSiteLoad struct {
+	ID   int    `json:"id"`
+	Name string `json:"name,omitempty"`
+	// MeanKeys and StddevKeys are the mean and standard deviation across
+	// seeds of the keys placed on the site.
+	MeanKeys   float64 `json:"meanKeys"`
+	StddevKeys float64 `json:"stddevKeys"`
+}
+
+// seedComparison is the result of --compareSeeds.
+type seedComparison struct {
+	Runs  []seedRun      `json:"runs"`
+	Sites []seedSiteLoad `json:"sites"`
+	// Best and Worst are the seeds with the lowest and highest hotspot
+	// factors.
+	Best  seedRun `json:"best"`
+	Worst seedRun `json:"worst"`
+}
+
+// compareSeeds places the distinct keys, ignoring fullness, on a fresh copy of
+// sites under each of --compareSeeds hash seeds, counting up from --seed, and
+// returns how much each site's load varied and which seeds placed keys most
+// and least evenly.
+func compareSeeds(sites []*hashing.Site, keys []string) (*seedComparison, error) {
+	keys = distinctKeys(keys)
+	caps := make([]int, len(sites))
+	for i, s := range sites {
+		caps[i] = s.Capacity()
+	}
+	cmp := &seedComparison{}
+	loads := make([][]float64, len(sites))
+	for i := 0; i < *compareSeedsN; i++ {
+		seed := *seed + int64(i)
+		hasher, err := newSeededHasher(*hashFunc, seed)
+		if err != nil {
+			return nil, err
+		}
+		ring, err := newPlacer(*algo, hasher, newSitesLike(sites, caps))
+		if err != nil {
+			return nil, err
+		}
+		demand := placementDemand(ring, keys)
+		var stats []SiteStat
+		for j, s := range ring.Sites() {
+			loads[j] = append(loads[j], float64(demand[s]))
+			stats = append(stats, SiteStat{ID: s.ID(), Capacity: s.Capacity(), Stored: demand[s]})
+		}
+		run := seedRun{Seed: seed}
+		run.HotspotSite, run.HotspotFactor = hotspotFactor(stats)
+		cmp.Runs = append(cmp.Runs, run)
+		if i == 0 || run.HotspotFactor < cmp.Best.HotspotFactor {
+			cmp.Best = run
+		}
+		if i == 0 || run.HotspotFactor > cmp.Worst.HotspotFactor {
+			cmp.Worst = run
+		}
+	}
+	for j, s := range sites {
+		load := seedSiteLoad{ID: s.ID(), Name: s.Name()}
+		for _, n := range loads[j] {
+			load.MeanKeys += n
+		}
+		load.MeanKeys /= float64(len(loads[j]))
+		for _, n := range loads[j] {
+			load.StddevKeys += (n - load.MeanKeys) * (n - load.MeanKeys)
+		}
+		load.StddevKeys = math.Sqrt(load.StddevKeys / float64(len(loads[j])))
+		cmp.Sites = append(cmp.Sites, load)
+	}
+	return cmp, nil
+}
+
+// printSeedComparison writes cmp as text, or as JSON with --output json.
+func printSeedComparison(w io.Writer, cmp *seedComparison) error {
+	if *output == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(cmp)
+	}
+	fmt.Fprintf(w, "%-10s %-12s %s\n", "site", "mean keys", "stddev keys")
+	for _, s := range cmp.Sites {
+		fmt.Fprintf(w, "%-10s %-12.2f %.2f\n", siteLabel(s.ID, s.Name), s.MeanKeys, s.StddevKeys)
+	}
+	label := func(id int) string {
+		for _, s := range cmp.Sites {
+			if s.ID == id {
+				return siteLabel(s.ID, s.Name)
+			}
+		}
+		return fmt.Sprint(id)
+	}
+	fmt.Fprintf(w, "best seed %d: hotspot site %s at %.2fx the mean fullness\n", cmp.Best.Seed, label(cmp.Best.HotspotSite), cmp.Best.HotspotFactor)
+	_, err := fmt.Fprintf(w, "worst seed %d: hotspot site %s at %.2fx the mean fullness\n", cmp.Worst.Seed, label(cmp.Worst.HotspotSite), cmp.Worst.HotspotFactor)
+	return err
+}
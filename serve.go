@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Timeouts for --serve, so that slow or idle clients can't hold connections
+// open indefinitely. Requests are small and answered from memory.
+const (
+	serveReadTimeout  = 5 * time.Second
+	serveWriteTimeout = 10 * time.Second
+	serveIdleTimeout  = time.Minute
+)
+
+// placement is the response to GET /place.
+type placement struct {
+	Key   string `json:"key"`
+	RF    int    `json:"rf"`
+	Sites []int  `json:"sites"`
+}
+
+// newServeMux returns the handlers for --serve: GET /place?key=K&rf=N returns
+// the ids of key's top N sites, most preferred first, with N defaulting to
+// --rf, and GET /stats returns the cluster's current stats. Placing keys
+// doesn't write them, so /stats reports each site's capacity and role but
+// nothing stored; it's there for checking the membership a server was
+// started with.
+func newServeMux(ring placer) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/place", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		key := r.URL.Query().Get("key")
+		if key == "" {
+			http.Error(w, "missing key", http.StatusBadRequest)
+			return
+		}
+		rf := *replicationFactor
+		if v := r.URL.Query().Get("rf"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > len(ring.Sites()) {
+				http.Error(w, fmt.Sprintf("invalid rf %q: want between 1 and %d", v, len(ring.Sites())), http.StatusBadRequest)
+				return
+			}
+			rf = n
+		}
+		p := placement{Key: key, RF: rf, Sites: []int{}}
+		for _, s := range ring.TopSites(key, rf) {
+			p.Sites = append(p.Sites, s.ID())
+		}
+		writeJSON(w, p)
+	})
+	mux.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		res := result{Sites: collectStats(ring.Sites())}
+		res.MeanStored, res.StddevStored, res.Gini = loadStats(res.Sites)
+		res.HotspotSite, res.HotspotFactor = hotspotFactor(res.Sites)
+		writeJSON(w, res)
+	})
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// runServe serves placements from ring on addr until the server fails.
+func runServe(ring placer, addr string) error {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           newServeMux(ring),
+		ReadHeaderTimeout: serveReadTimeout,
+		ReadTimeout:       serveReadTimeout,
+		WriteTimeout:      serveWriteTimeout,
+		IdleTimeout:       serveIdleTimeout,
+	}
+	infof("serving placements on %s\n", addr)
+	return srv.ListenAndServe()
+}
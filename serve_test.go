@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestServePlace(t *testing.T) {
+	setFlag(t, "rf", "2")
+	ring := newTestRing(t, 100, 200, 300)
+	mux := newServeMux(ring)
+	tests := []struct {
+		name   string
+		method string
+		target string
+		code   int
+		rf     int
+	}{
+		{"default rf", http.MethodGet, "/place?key=k", http.StatusOK, 2},
+		{"explicit rf", http.MethodGet, "/place?key=k&rf=3", http.StatusOK, 3},
+		{"rf too big", http.MethodGet, "/place?key=k&rf=4", http.StatusBadRequest, 0},
+		{"rf not a number", http.MethodGet, "/place?key=k&rf=two", http.StatusBadRequest, 0},
+		{"rf zero", http.MethodGet, "/place?key=k&rf=0", http.StatusBadRequest, 0},
+		{"missing key", http.MethodGet, "/place?rf=1", http.StatusBadRequest, 0},
+		{"post", http.MethodPost, "/place?key=k", http.StatusMethodNotAllowed, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.target, nil))
+			if rec.Code != tt.code {
+				t.Fatalf("%s %s = %d, want %d: %s", tt.method, tt.target, rec.Code, tt.code, rec.Body)
+			}
+			if tt.code != http.StatusOK {
+				return
+			}
+			var got placement
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			var want []int
+			for _, s := range ring.TopSites("k", tt.rf) {
+				want = append(want, s.ID())
+			}
+			if got.Key != "k" || got.RF != tt.rf || !slices.Equal(got.Sites, want) {
+				t.Errorf("%s = %+v, want key k, rf %d and sites %v", tt.target, got, tt.rf, want)
+			}
+		})
+	}
+}
+
+func TestServeStats(t *testing.T) {
+	mux := newServeMux(newTestRing(t, 100, 200, 300))
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stats", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /stats = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+		t.Errorf("Content-Type = %q, want JSON", ct)
+	}
+	var res result
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	var caps []int
+	for _, s := range res.Sites {
+		caps = append(caps, s.Capacity)
+		if s.Stored != 0 {
+			t.Errorf("site %d stores %d keys, want 0 since --serve doesn't write", s.ID, s.Stored)
+		}
+	}
+	if !slices.Equal(caps, []int{100, 200, 300}) {
+		t.Errorf("/stats site capacities = %v, want [100 200 300]", caps)
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/stats", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("DELETE /stats = %d, want 405", rec.Code)
+	}
+}
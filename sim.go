@@ -0,0 +1,1055 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"example.com/mod/hashing"
+)
+
+// simulation is the state of a run over a ring.
+type simulation struct {
+	ring          placer
+	unableToWrite map[string]struct{}
+	reads, writes int
+	timeline      []timelinePoint
+
+	// achieved counts writes by the number of replicas placed.
+	achieved []int
+
+	// hops counts read hits by the position in the key's site ordering of
+	// the site that served them.
+	hops []int
+
+	// tally counts the keys each site would store, in place of storing them,
+	// for a dry run. It's nil otherwise. tallied holds the keys counted, so
+	// that updates aren't counted again.
+	tally   map[*hashing.Site]int
+	tallied map[string]struct{}
+
+	// failed are the ids of the sites taken offline halfway through.
+	failed []int
+
+	// scaleSite is the site whose capacity changes to --scaleTo partway
+	// through, and scale reports its share of keys before and after.
+	scaleSite *hashing.Site
+	scale     *scaleResult
+
+	// trace records each write's placement, with --traceFile.
+	trace *tracer
+
+	// sampledOf is the number of keys the written keys were sampled from,
+	// with --sampleKeys, or 0.
+	sampledOf int
+
+	// decay tracks the --decaySite as its capacity shrinks.
+	decay *decayResult
+
+	// stop, when closed, ends the run early, and interrupted records that it
+	// did.
+	stop        <-chan struct{}
+	interrupted bool
+
+	// uniformHitPct is the hit rate uniform reads of the written keys would
+	// have seen, to compare against --readsFollowWrites.
+	uniformHitPct float64
+
+	// burnedIn counts the reads left out of the stats so far, up to
+	// --burnIn.
+	burnedIn int
+
+	// deletes counts the deletes replayed.
+	deletes int
+
+	// malformed counts the lines of --replay input that weren't operations.
+	malformed int
+
+	// distinctWrites is whether every key written is different, so that
+	// writes can be short-circuited once the cluster is exhausted, and
+	// shortCircuited counts those that were.
+	distinctWrites bool
+	shortCircuited int
+
+	// op is the operation a --mixedOps run is on, and expiry drops keys
+	// --ttl operations after they're written. expiryMisses counts the reads
+	// that missed a key because its copies expired.
+	op           int
+	expiry       *expiry
+	expiryMisses int
+
+	// pins maps keys pinned with --pins to their sites, and pinFailures
+	// counts writes of pinned keys that their full site couldn't take.
+	pins        map[string]*hashing.Site
+	pinFailures int
+
+	// alerts records when each site first crosses --alertAt fullness.
+	alerts *headroomAlerts
+
+	// fullReplicas counts writes by how many of their top replicationFactor
+	// sites were full, for those that found any full.
+	fullReplicas []int
+
+	// ackFailures counts the writes rejected because fewer than --writeAck
+	// sites could take them.
+	ackFailures int
+
+	// readOnlyBlocks counts the writes with a read-only site among their
+	// top replicationFactor sites, and writeOnlySkips the write-only sites
+	// that reads passed over.
+	readOnlyBlocks int
+	writeOnlySkips int
+
+	// p2c places writes by the power of two choices, with --writeStrategy
+	// p2c.
+	p2c *p2c
+
+	// placements records where a sample of keys were written, with
+	// --checkPlacement.
+	placements *placementCheck
+
+	// collisions counts the written keys for which sites' scores collide,
+	// with --detectCollisions.
+	collisions *collisionCount
+
+	// copiesFound counts reads by how many of the key's replica sites held
+	// it, with --checkAllReplicas.
+	copiesFound []int
+
+	// latencies are the milliseconds a read takes to probe each site, with
+	// --siteLatencies, and readLatencies the milliseconds each read took.
+	latencies     map[*hashing.Site]float64
+	readLatencies []float64
+
+	// quorumHits counts reads that found the key on --readQuorum sites, and
+	// quorumFailures those that found it on some sites but fewer.
+	quorumHits     int
+	quorumFailures int
+
+	// zoneFallbacks counts writes whose replica sites couldn't all be in
+	// distinct zones.
+	zoneFallbacks int
+
+	// failoverReads counts reads served by a replica because the key's
+	// primary site was offline.
+	failoverReads int
+
+	// repairs counts keys backfilled onto a preferred replica by a read, and
+	// skippedRepairs those that couldn't be because the replica was full.
+	repairs        int
+	skippedRepairs int
+}
+
+func newSimulation(ring placer) *simulation {
+	sim := &simulation{ring: ring, unableToWrite: make(map[string]struct{})}
+	if *writeStrategy == "p2c" {
+		sim.p2c = newP2C()
+	}
+	if *burnIn > 0 {
+		sim.countReads(false)
+	}
+	if *topKeys > 0 {
+		for _, s := range ring.Sites() {
+			s.TrackKeyReads()
+		}
+	}
+	return sim
+}
+
+// countReads controls whether the sites count reads as hits and misses.
+func (sim *simulation) countReads(count bool) {
+	for _, s := range sim.ring.Sites() {
+		s.SetCountReads(count)
+	}
+}
+
+// write stores key on its replica sites per --writeStrategy: all of its top
+// replicationFactor sites or none, as many of them as can take it, or the
+// first replicationFactor that can take it; or, with p2c, on sites chosen by
+// the power of two choices. A site can't take a key if it's offline or, when
+// rejecting, full. The key is unable to write if no replica could be placed.
+func (sim *simulation) write(key string) {
+	if sim.p2c != nil && sim.p2c.placed[key] == nil {
+		sim.writeTo(key, sim.p2c.choose(sim))
+		return
+	}
+	sim.writeTo(key, sim.candidates(key))
+}
+
+// candidates returns the sites a write of key may go to, in preference order:
+// its replicationFactor replicas, or every site when writes overflow. With
+// --replicaPlacement spread, the replicas are spread across the preference
+// order; see spreadRanks. With --siteZones, sites are spread across zones
+// first; see spreadZones. With p2c, they're the sites key was placed on, if
+// it has been. A key pinned with --pins has its pinned site first, followed
+// by the rest in order.
+func (sim *simulation) candidates(key string) []*hashing.Site {
+	if sim.p2c != nil {
+		return sim.p2c.placed[key]
+	}
+	if pin, ok := sim.pins[key]; ok {
+		sites := []*hashing.Site{pin}
+		for _, s := range sim.ring.OrderedSites(key) {
+			if s != pin {
+				sites = append(sites, s)
+			}
+		}
+		if *writeStrategy == "overflow" {
+			return sites
+		}
+		return sites[:min(*replicationFactor, len(sites))]
+	}
+	if *siteZones != "" {
+		sites := spreadZones(sim.ring.OrderedSites(key), *replicationFactor)
+		if *writeStrategy == "overflow" {
+			return sites
+		}
+		return sites[:min(*replicationFactor, len(sites))]
+	}
+	if *writeStrategy == "overflow" {
+		if *replicaPlacement == "spread" {
+			return spreadRanks(sim.ring.OrderedSites(key), *replicationFactor)
+		}
+		return sim.ring.OrderedSites(key)
+	}
+	return replicas(sim.ring, key)
+}
+
+// replicas returns key's replicationFactor replica sites on ring, per
+// --replicaPlacement: its top sites, or with spread, those spreadRanks picks.
+func replicas(ring placer, key string) []*hashing.Site {
+	if *replicaPlacement == "spread" {
+		sites := spreadRanks(ring.OrderedSites(key), *replicationFactor)
+		return sites[:min(*replicationFactor, len(sites))]
+	}
+	return ring.TopSites(key, *replicationFactor)
+}
+
+// spreadRanks reorders sites, which are in preference order, so that the
+// first n are those at evenly spaced ranks 0, N/n, 2N/n and so on of the N
+// sites, rather than the top n. The rest follow in order.
+func spreadRanks(sites []*hashing.Site, n int) []*hashing.Site {
+	spread := make([]*hashing.Site, 0, len(sites))
+	picked := make([]bool, len(sites))
+	for i := 0; i < min(n, len(sites)); i++ {
+		j := i * len(sites) / n
+		picked[j] = true
+		spread = append(spread, sites[j])
+	}
+	for j, s := range sites {
+		if !picked[j] {
+			spread = append(spread, s)
+		}
+	}
+	return spread
+}
+
+// spreadZones reorders sites, which are in preference order, so that the first
+// n are in distinct zones: after the primary, sites in a zone already picked
+// are skipped until n zones are covered. If there are fewer than n zones, the
+// skipped sites fill in, most preferred first. The rest follow in order.
+func spreadZones(sites []*hashing.Site, n int) []*hashing.Site {
+	spread := make([]*hashing.Site, 0, len(sites))
+	var skipped []*hashing.Site
+	zones := make(map[string]bool)
+	for i, s := range sites {
+		if len(zones) == n {
+			spread = append(spread, skipped...)
+			return append(spread, sites[i:]...)
+		}
+		if zones[s.Zone()] {
+			skipped = append(skipped, s)
+			continue
+		}
+		zones[s.Zone()] = true
+		spread = append(spread, s)
+	}
+	return append(spread, skipped...)
+}
+
+// distinctZones returns the number of distinct zones sites are in.
+func distinctZones(sites []*hashing.Site) int {
+	zones := make(map[string]bool)
+	for _, s := range sites {
+		zones[s.Zone()] = true
+	}
+	return len(zones)
+}
+
+// writeTo is write with key's candidate sites already computed.
+func (sim *simulation) writeTo(key string, candidates []*hashing.Site) {
+	sim.writes++
+	if sim.collisions != nil {
+		if pairs := sim.collisions.ring.Collisions(key); pairs > 0 {
+			sim.collisions.keys++
+			sim.collisions.pairs += pairs
+		}
+	}
+	if *siteZones != "" && distinctZones(candidates[:min(*replicationFactor, len(candidates))]) < *replicationFactor {
+		sim.zoneFallbacks++
+	}
+	if sim.placements != nil {
+		sim.placements.record(key, candidates)
+	}
+	if pin, ok := sim.pins[key]; ok && pin.Online() && !sim.canTake(pin, key) {
+		sim.pinFailures++
+	}
+	for _, s := range candidates[:min(*replicationFactor, len(candidates))] {
+		if s.ReadOnly() {
+			sim.readOnlyBlocks++
+			break
+		}
+	}
+	// Count the top replicationFactor sites that can't take the write, and
+	// of those, the ones that are up and writable but full.
+	var unavailable, full int
+	for _, s := range candidates[:min(*replicationFactor, len(candidates))] {
+		if sim.canTake(s, key) {
+			continue
+		}
+		unavailable++
+		if s.Online() && !s.ReadOnly() {
+			full++
+		}
+	}
+	if full > 0 {
+		for len(sim.fullReplicas) <= full {
+			sim.fullReplicas = append(sim.fullReplicas, 0)
+		}
+		sim.fullReplicas[full]++
+	}
+	var placed []*hashing.Site
+	switch *writeStrategy {
+	case "all":
+		if unavailable == 0 {
+			placed = candidates
+		}
+	case "best-effort", "p2c":
+		for _, s := range candidates {
+			if sim.canTake(s, key) {
+				placed = append(placed, s)
+			}
+		}
+	case "overflow":
+		for _, s := range candidates {
+			if len(placed) == *replicationFactor {
+				break
+			}
+			if sim.canTake(s, key) {
+				placed = append(placed, s)
+			}
+		}
+	}
+
+	// Fewer than --writeAck replicas rejects the write, placing none of
+	// them.
+	var unacked int
+	if len(placed) > 0 && len(placed) < *writeAck {
+		unacked = len(placed)
+		placed = nil
+		sim.ackFailures++
+	}
+	for len(sim.achieved) <= len(placed) {
+		sim.achieved = append(sim.achieved, 0)
+	}
+	sim.achieved[len(placed)]++
+	if sim.trace != nil {
+		var reason string
+		switch {
+		case unacked > 0:
+			reason = fmt.Sprintf("only %d sites could take it, fewer than --writeAck %d", unacked, *writeAck)
+		case len(placed) == 0:
+			reason = sim.refusal(key, candidates)
+		}
+		sim.trace.trace(key, candidates, placed, reason)
+	}
+	if len(placed) == 0 {
+		sim.unableToWrite[key] = struct{}{}
+		if *verbose {
+			verbosef("write of key %s rejected: tried sites %s\n", key, siteLabels(candidates))
+		}
+		return
+	}
+	delete(sim.unableToWrite, key)
+	if sim.p2c != nil && sim.p2c.placed[key] == nil {
+		sim.p2c.placed[key] = placed
+	}
+	if sim.tally != nil {
+		if _, ok := sim.tallied[key]; ok {
+			return
+		}
+		sim.tallied[key] = struct{}{}
+	}
+	for _, s := range placed {
+		if sim.tally == nil {
+			s.HandleWrite(key)
+			if sim.expiry != nil {
+				sim.expiry.stamp(sim.op, s, key)
+			}
+		} else if !sim.full(s) {
+			sim.tally[s]++
+		}
+		if sim.alerts != nil {
+			sim.alerts.check(sim, s)
+		}
+	}
+}
+
+// delete removes key from each of its online replica sites, found as a write
+// would find them, and forgets that it was unable to write, so later reads of
+// it miss. Dry runs store nothing to delete from, so only count it.
+func (sim *simulation) delete(key string) {
+	sim.deletes++
+	delete(sim.unableToWrite, key)
+	if sim.p2c != nil {
+		defer delete(sim.p2c.placed, key)
+	}
+	if sim.tally != nil {
+		return
+	}
+	for _, s := range sim.candidates(key) {
+		if s.Online() {
+			s.HandleDelete(key)
+		}
+	}
+}
+
+// siteLabels returns sites' labels separated by commas.
+func siteLabels(sites []*hashing.Site) string {
+	labels := make([]string, len(sites))
+	for i, s := range sites {
+		labels[i] = s.Label()
+	}
+	return strings.Join(labels, ",")
+}
+
+// collisionCount tallies score collisions between ring's sites.
+type collisionCount struct {
+	ring  *hashing.Ring
+	keys  int
+	pairs int
+}
+
+// exhausted reports whether no site can take any new key, so writes needn't
+// be placed to know they'll be rejected. That's only so when every key
+// written is new: a site that's full can still take an update to a key it
+// holds. Evicting sites are never exhausted.
+func (sim *simulation) exhausted() bool {
+	if !sim.distinctWrites || *onFull == "evict" {
+		return false
+	}
+	for _, s := range sim.ring.Sites() {
+		if s.Online() && !sim.full(s) {
+			return false
+		}
+	}
+	return true
+}
+
+// shortCircuit rejects a write of key without placing it, once the cluster is
+// exhausted.
+func (sim *simulation) shortCircuit(key string) {
+	sim.writes++
+	sim.shortCircuited++
+	if len(sim.achieved) == 0 {
+		sim.achieved = append(sim.achieved, 0)
+	}
+	sim.achieved[0]++
+	sim.unableToWrite[key] = struct{}{}
+	if sim.trace != nil {
+		sim.trace.trace(key, nil, nil, "no site has capacity left")
+	}
+	if *verbose {
+		verbosef("write of key %s rejected: no site has capacity left\n", key)
+	}
+}
+
+// refusal says why the first of candidates that can't take a write of key
+// can't.
+func (sim *simulation) refusal(key string, candidates []*hashing.Site) string {
+	for _, s := range candidates {
+		switch {
+		case !s.Online():
+			return fmt.Sprintf("site %s is offline", s.Label())
+		case s.ReadOnly():
+			return fmt.Sprintf("site %s is read-only", s.Label())
+		case !sim.canTake(s, key):
+			return fmt.Sprintf("site %s is full", s.Label())
+		}
+	}
+	return "no candidate sites"
+}
+
+// canTake reports whether s can take a write of key. A site that already holds
+// key can always take an update to it, unless it's read-only.
+func (sim *simulation) canTake(s *hashing.Site, key string) bool {
+	return s.Online() && !s.ReadOnly() && (*onFull == "evict" || s.Has(key) || !sim.full(s))
+}
+
+// full reports whether s is full, or would be in a dry run.
+func (sim *simulation) full(s *hashing.Site) bool {
+	if sim.tally != nil {
+		return sim.tally[s] >= s.Capacity()
+	}
+	return s.Full()
+}
+
+// fullness returns the fraction of s's capacity in use, or that would be in a
+// dry run.
+func (sim *simulation) fullness(s *hashing.Site) float64 {
+	stored := s.Stored()
+	if sim.tally != nil {
+		stored = sim.tally[s]
+	}
+	return float64(stored) / float64(s.Capacity())
+}
+
+// read walks key's online replica sites in preference order until one holds
+// it, or --readQuorum of them do. Only the top replicationFactor sites are
+// checked, since those are the only sites a write places the key on, unless
+// writes overflow onto later sites. With --readRepair, the key is copied onto
+// the sites that missed before the hit. The first --burnIn reads are left out
+// of the stats. With --checkAllReplicas, each read also counts how many of the
+// key's replica sites hold it.
+func (sim *simulation) read(key string) {
+	counting := sim.burnedIn >= *burnIn
+	if counting {
+		sim.reads++
+	} else {
+		sim.burnedIn++
+		if sim.burnedIn == *burnIn {
+			defer sim.countReads(true)
+		}
+	}
+	if counting && *checkAllReplicas {
+		sim.countCopies(key)
+	}
+	if _, ok := sim.unableToWrite[key]; ok {
+		if counting && sim.latencies != nil {
+			sim.readLatencies = append(sim.readLatencies, sim.missLatency(key))
+		}
+		return
+	}
+	sites := sim.candidates(key)
+	var missed []*hashing.Site
+	var held int
+	var latency float64
+	for i, s := range sites {
+		if !s.Online() {
+			continue
+		}
+		if s.WriteOnly() {
+			if counting {
+				sim.writeOnlySkips++
+			}
+			continue
+		}
+		latency += sim.latencies[s]
+		if !s.HandleRead(key) {
+			missed = append(missed, s)
+			continue
+		}
+		if held++; held < *readQuorum {
+			continue
+		}
+		if counting {
+			sim.quorumHits++
+			if sim.latencies != nil {
+				sim.readLatencies = append(sim.readLatencies, latency)
+			}
+			for len(sim.hops) <= i {
+				sim.hops = append(sim.hops, 0)
+			}
+			sim.hops[i]++
+			if !sites[0].Online() {
+				sim.failoverReads++
+			}
+		}
+		if *readRepair {
+			sim.repair(key, missed)
+		}
+		return
+	}
+	if counting && held > 0 {
+		sim.quorumFailures++
+	}
+	if counting && sim.expiry != nil {
+		if _, ok := sim.expiry.expired[key]; ok {
+			sim.expiryMisses++
+		}
+	}
+	if counting && sim.latencies != nil {
+		sim.readLatencies = append(sim.readLatencies, sim.missLatency(key))
+	}
+}
+
+// countCopies records how many of key's top replicationFactor sites are online
+// and hold it. It checks with Has rather than HandleRead so the sites' read
+// stats still reflect only the read walk.
+func (sim *simulation) countCopies(key string) {
+	if sim.copiesFound == nil {
+		sim.copiesFound = make([]int, *replicationFactor+1)
+	}
+	sites := sim.candidates(key)
+	var copies int
+	for _, s := range sites[:min(*replicationFactor, len(sites))] {
+		if s.Online() && s.Has(key) {
+			copies++
+		}
+	}
+	sim.copiesFound[copies]++
+}
+
+// missLatency returns the latency charged to a read of key that misses: that
+// of probing each of its top replicationFactor sites.
+func (sim *simulation) missLatency(key string) float64 {
+	var latency float64
+	for _, s := range sim.ring.TopSites(key, *replicationFactor) {
+		latency += sim.latencies[s]
+	}
+	return latency
+}
+
+// repair writes key to each of sites that has room for it.
+func (sim *simulation) repair(key string, sites []*hashing.Site) {
+	for _, s := range sites {
+		if s.ReadOnly() {
+			continue
+		}
+		if s.Full() {
+			sim.skippedRepairs++
+			continue
+		}
+		s.HandleWrite(key)
+		if sim.expiry != nil {
+			sim.expiry.stamp(sim.op, s, key)
+		}
+		sim.repairs++
+	}
+}
+
+// run writes every key, then does --numReads reads of keys from nextReadKey.
+// With --workers, the writes' sites are placed a batch at a time in parallel,
+// then written in order.
+func (sim *simulation) run(keys []string, nextReadKey func() string) error {
+	var batch [][]*hashing.Site
+	for i, key := range keys {
+		if sim.stopped() {
+			return nil
+		}
+		if err := sim.beforeOp(i, len(keys), keys[:i]); err != nil {
+			return err
+		}
+		sim.op = i
+		sim.progress("writes", i, len(keys))
+		if sim.exhausted() {
+			sim.shortCircuit(key)
+			if len(batch) > 0 {
+				batch = batch[1:]
+			}
+			continue
+		}
+		if *workers <= 1 {
+			sim.write(key)
+			continue
+		}
+		if len(batch) == 0 {
+			batch = sim.placeParallel(keys[i:sim.batchEnd(i, len(keys))])
+		}
+		sim.writeTo(key, batch[0])
+		batch = batch[1:]
+	}
+	sim.writesDone()
+	if sim.tally != nil {
+		return nil
+	}
+	sim.progress("writes", len(keys), len(keys))
+	for i := 0; i < *numReads; i++ {
+		if sim.stopped() {
+			return nil
+		}
+		sim.progress("reads", i, *numReads)
+		sim.read(nextReadKey())
+	}
+	sim.progress("reads", *numReads, *numReads)
+	if *readsFollowWrites {
+		sim.uniformHitPct = sim.heldPct(keys)
+	}
+	return nil
+}
+
+// batchEnd returns the end of the batch of writes starting at op i of n to
+// place in parallel. Batches stop short of the ops where beforeOp changes
+// membership or capacities, since those change placement.
+func (sim *simulation) batchEnd(i, n int) int {
+	end := i + *workers*1024
+	if end > n {
+		end = n
+	}
+	for _, op := range []int{n / 2, int(*scaleAt * float64(n))} {
+		if op > i && op < end {
+			end = op
+		}
+	}
+	return end
+}
+
+// placeParallel returns the candidate sites of each of keys, computed across
+// --workers goroutines. Placement only reads the ring and its sites, so it's
+// safe to run concurrently as long as nothing writes to them meanwhile.
+func (sim *simulation) placeParallel(keys []string) [][]*hashing.Site {
+	placements := make([][]*hashing.Site, len(keys))
+	var wg sync.WaitGroup
+	for w := 0; w < *workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < len(keys); i += *workers {
+				placements[i] = sim.candidates(keys[i])
+			}
+		}(w)
+	}
+	wg.Wait()
+	return placements
+}
+
+// heldPct returns the percentage of keys that an online replica holds, which
+// is the hit rate reads picking uniformly among keys would see.
+func (sim *simulation) heldPct(keys []string) float64 {
+	if len(keys) == 0 {
+		return 0
+	}
+	var held int
+	for _, key := range keys {
+		for _, s := range sim.ring.TopSites(key, *replicationFactor) {
+			if s.Online() && s.Has(key) {
+				held++
+				break
+			}
+		}
+	}
+	return float64(held) / float64(len(keys)) * 100
+}
+
+// runMixed does --mixedOps interleaved operations, each a read with
+// probability --readRatio. Writes take the next unwritten key and reads pick
+// uniformly among the keys written so far. Once keys run out every operation
+// is a read. Site fullness is recorded 10%, 50% and 100% of the way through.
+func (sim *simulation) runMixed(keys []string, rng *rand.Rand) error {
+	var w int
+	n := *mixedOps
+	checkpoints := []int{10, 50, 100}
+	for op := 0; op < n; op++ {
+		if sim.stopped() {
+			return nil
+		}
+		if err := sim.beforeOp(op, n, keys[:w]); err != nil {
+			return err
+		}
+		sim.op = op
+		sim.progress("ops", op, n)
+		if sim.expiry != nil {
+			sim.expiry.expire(op)
+		}
+		if w > 0 && (w == len(keys) || rng.Float64() < *readRatio) {
+			if sim.tally == nil {
+				sim.read(keys[rng.Intn(w)])
+			}
+		} else {
+			sim.write(keys[w])
+			w++
+		}
+		for len(checkpoints) > 0 && (op+1)*100 >= checkpoints[0]*n {
+			sim.record(checkpoints[0])
+			checkpoints = checkpoints[1:]
+		}
+	}
+	sim.progress("ops", n, n)
+	sim.writesDone()
+	return nil
+}
+
+// progress writes a progress line to stderr every --progress operations of a
+// phase: done of total operations, if total is known, and how full the
+// cluster is.
+func (sim *simulation) progress(phase string, done, total int) {
+	if *progress <= 0 || done == 0 || done%*progress != 0 {
+		return
+	}
+	var stored, capacity int
+	for _, s := range sim.ring.Sites() {
+		capacity += s.Capacity()
+		if sim.tally != nil {
+			stored += sim.tally[s]
+		} else {
+			stored += s.Stored()
+		}
+	}
+	if total > 0 {
+		fmt.Fprintf(os.Stderr, "progress: %s %d/%d (%.0f%%), cluster %.2f%% full\n", phase, done, total, pct(done, total), pct(stored, capacity))
+	} else {
+		fmt.Fprintf(os.Stderr, "progress: %s %d, cluster %.2f%% full\n", phase, done, pct(stored, capacity))
+	}
+}
+
+// stopped reports whether the run has been asked to stop, recording that it
+// was interrupted if so.
+func (sim *simulation) stopped() bool {
+	select {
+	case <-sim.stop:
+		sim.interrupted = true
+		return true
+	default:
+		return false
+	}
+}
+
+// beforeOp applies the changes scheduled partway through a run of n
+// operations that are due before operation op. Halfway through, --churn is
+// applied and the failed sites go offline; at --scaleAt the scaled site's
+// capacity changes, and with --rebalance keys move to match; and throughout,
+// the --decaySite's capacity shrinks. attempted are the keys written so far.
+func (sim *simulation) beforeOp(op, n int, attempted []string) error {
+	if sim.decay != nil {
+		sim.applyDecay(op, n)
+	}
+	if op == n/2 {
+		if *churn != "" {
+			if err := sim.applyChurn(attempted); err != nil {
+				return err
+			}
+		}
+		sim.fail(sim.failed)
+	}
+	if sim.scaleSite != nil && op == int(*scaleAt*float64(n)) {
+		sim.scale = &scaleResult{
+			ID:          sim.scaleSite.ID(),
+			From:        sim.scaleSite.Capacity(),
+			To:          *scaleTo,
+			SharePct:    storedSharePct(sim.scaleSite, sim.ring.Sites()),
+			storedAt:    sim.scaleSite.Stored(),
+			allStoredAt: totalStored(sim.ring.Sites()),
+		}
+		sim.scaleSite.SetCapacity(*scaleTo)
+		if *rebalance {
+			sim.scale.Rebalanced = sim.ring.Rebalance(sim.writtenKeys(attempted), *replicationFactor)
+			sim.scale.storedAt = sim.scaleSite.Stored()
+			sim.scale.allStoredAt = totalStored(sim.ring.Sites())
+		}
+	}
+	return nil
+}
+
+// applyDecay shrinks the --decaySite's capacity linearly from its starting
+// capacity, losing --decayRate of it by the end of the run's n operations, and
+// records its capacity and share of stored keys every quarter of the way.
+func (sim *simulation) applyDecay(op, n int) {
+	d := sim.decay
+	d.site.SetCapacity(int(math.Round(float64(d.From) * (1 - *decayRate*float64(op)/float64(n)))))
+	if len(d.Points) < 4 && op == len(d.Points)*n/4 {
+		sim.recordDecay(len(d.Points) * 25)
+	}
+}
+
+// recordDecay adds the --decaySite's current capacity and share of keys to
+// its trajectory.
+func (sim *simulation) recordDecay(opsPct int) {
+	d := sim.decay
+	p := decayPoint{OpsPct: opsPct, Capacity: d.site.Capacity(), SharePct: storedSharePct(d.site, sim.ring.Sites())}
+	if since := totalStored(sim.ring.Sites()) - d.allStoredAt; since > 0 {
+		p.SinceSharePct = float64(d.site.Stored()-d.storedAt) / float64(since) * 100
+	}
+	d.storedAt, d.allStoredAt = d.site.Stored(), totalStored(sim.ring.Sites())
+	d.Points = append(d.Points, p)
+}
+
+// writesDone records the effect of --scaleTo and --decaySite once writing has
+// finished.
+func (sim *simulation) writesDone() {
+	if sim.decay != nil {
+		sim.recordDecay(100)
+	}
+	if sim.scale == nil {
+		return
+	}
+	sim.scale.ShareAfterPct = storedSharePct(sim.scaleSite, sim.ring.Sites())
+	if since := totalStored(sim.ring.Sites()) - sim.scale.allStoredAt; since > 0 {
+		sim.scale.ShareSincePct = float64(sim.scaleSite.Stored()-sim.scale.storedAt) / float64(since) * 100
+	}
+}
+
+// record adds the sites' current fullness to the timeline.
+func (sim *simulation) record(opsPct int) {
+	p := timelinePoint{OpsPct: opsPct}
+	for _, s := range collectStats(sim.ring.Sites()) {
+		p.Sites = append(p.Sites, siteFullness{ID: s.ID, FullnessPct: s.FullnessPct})
+	}
+	sim.timeline = append(sim.timeline, p)
+}
+
+// fail takes the sites with the given ids offline.
+func (sim *simulation) fail(ids []int) {
+	for _, id := range ids {
+		if s := siteByID(sim.ring.Sites(), id); s != nil {
+			s.SetOnline(false)
+		}
+	}
+}
+
+// applyChurn applies the --churn membership change to the ring after the
+// attempted writes, moving keys held by a removed site onto its successors or,
+// with --prewarm, copying keys onto an added site, and prints the fraction of
+// written keys whose primary site changed.
+func (sim *simulation) applyChurn(attempted []string) error {
+	parts := strings.SplitN(*churn, ":", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("invalid --churn %q: want add:<capacity> or remove:<site id>", *churn)
+	}
+	n, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return fmt.Errorf("invalid --churn %q: %v", *churn, err)
+	}
+
+	ring := sim.ring
+	before := ring.Sites()
+	switch parts[0] {
+	case "add":
+		s := hashing.NewSite(nextSiteID(ring.Sites()), n)
+		if *topKeys > 0 {
+			s.TrackKeyReads()
+		}
+		ring.AddSite(s)
+		if *prewarm {
+			copied := ring.WarmNewSite(s, sim.writtenKeys(attempted), *replicationFactor)
+			infof("pre-warming copied %d keys onto site %s\n", copied, s.Label())
+		}
+	case "remove":
+		removed := ring.RemoveSite(n)
+		if removed == nil {
+			return fmt.Errorf("invalid --churn %q: no site with id %d", *churn, n)
+		}
+		if len(ring.Sites()) < *replicationFactor {
+			return fmt.Errorf("invalid --churn %q: replication factor %d is greater than remaining sites (%d)", *churn, *replicationFactor, len(ring.Sites()))
+		}
+		for _, key := range removed.Keys() {
+			for _, s := range ring.TopSites(key, *replicationFactor) {
+				if !s.Has(key) && !s.Full() {
+					s.HandleWrite(key)
+				}
+			}
+		}
+	default:
+		return fmt.Errorf("invalid --churn %q: want add:<capacity> or remove:<site id>", *churn)
+	}
+
+	written := sim.writtenKeys(attempted)
+	infof("churn %s: %.2f%% of written keys changed primary site\n", *churn, ring.RemapFraction(written, before, ring.Sites())*100)
+	return nil
+}
+
+// writtenKeys returns the distinct keys in attempted that were written.
+func (sim *simulation) writtenKeys(attempted []string) []string {
+	var written []string
+	seen := make(map[string]struct{})
+	for _, key := range attempted {
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		if _, ok := sim.unableToWrite[key]; !ok {
+			written = append(written, key)
+		}
+	}
+	return written
+}
+
+func (sim *simulation) result() result {
+	res := result{
+		Sites:          collectStats(sim.ring.Sites()),
+		Reads:          sim.reads,
+		Writes:         sim.writes,
+		UnableToWrite:  len(sim.unableToWrite),
+		ShortCircuited: sim.shortCircuited,
+		AckFailures:    sim.ackFailures,
+		FullReplicas:   sim.fullReplicas,
+		CopiesFound:    sim.copiesFound,
+		Timeline:       sim.timeline,
+		Scale:          sim.scale,
+		Decay:          sim.decay,
+		ReadHops:       sim.hops,
+		Achieved:       sim.achieved,
+		FailoverReads:  sim.failoverReads,
+		Repairs:        sim.repairs,
+		SkippedRepairs: sim.skippedRepairs,
+		UniformHitPct:  sim.uniformHitPct,
+		ZoneFallbacks:  sim.zoneFallbacks,
+		QuorumFailures: sim.quorumFailures,
+		Malformed:      sim.malformed,
+		Deletes:        sim.deletes,
+		Interrupted:    sim.interrupted,
+	}
+	if sim.alerts != nil {
+		res.Alerts = sim.alerts.crossed
+	}
+	if sim.pins != nil {
+		res.PinFailures = sim.pinFailures
+	}
+	if *readOnlySites != "" || *writeOnlySites != "" {
+		res.Roles = &rolesResult{ReadOnlyBlocks: sim.readOnlyBlocks, WriteOnlySkips: sim.writeOnlySkips}
+	}
+	if c := sim.collisions; c != nil {
+		res.Collisions = &collisionResult{Keys: c.keys, Pairs: c.pairs, KeysPct: pct(c.keys, sim.writes)}
+	}
+	if sim.expiry != nil {
+		res.Expiry = &expiryResult{
+			Copies:          sim.expiry.copies,
+			ExpiryMisses:    sim.expiryMisses,
+			PlacementMisses: sim.reads - sim.quorumHits - sim.expiryMisses,
+		}
+	}
+	if sim.tally != nil {
+		for i, s := range sim.ring.Sites() {
+			res.Sites[i].Stored = sim.tally[s]
+			res.Sites[i].FullnessPct = pct(sim.tally[s], s.Capacity())
+		}
+	}
+	for _, s := range res.Sites {
+		res.Evictions += s.Evictions
+	}
+	if res.Reads > 0 {
+		res.ReadHitPct = float64(sim.quorumHits) / float64(res.Reads) * 100
+	}
+	res.MeanStored, res.StddevStored, res.Gini = loadStats(res.Sites)
+	res.HotspotSite, res.HotspotFactor = hotspotFactor(res.Sites)
+	if sim.tally != nil {
+		res.DistinctKeys = len(sim.tallied)
+	} else {
+		res.DistinctKeys = distinctStored(sim.ring.Sites())
+	}
+	res.Copies, res.CapacityUsedPct = copiesOf(res.Sites)
+	if sim.sampledOf > 0 {
+		res.Sample = extrapolate(res, sim.sampledOf)
+	}
+	if sim.latencies != nil {
+		ps := []float64{50, 90, 99}
+		values := percentilesOf(sim.readLatencies, ps...)
+		for _, p := range ps {
+			res.ReadLatencyPercentiles = append(res.ReadLatencyPercentiles, percentile{P: p, Value: values[p]})
+		}
+	}
+	if *shares {
+		res.Shares = sharesOf(res.Sites)
+	}
+	if *percentiles {
+		ps := []float64{50, 90, 99}
+		values := loadPercentiles(res.Sites, ps...)
+		for _, p := range ps {
+			res.FullnessPercentiles = append(res.FullnessPercentiles, percentile{P: p, Value: values[p]})
+		}
+	}
+	return res
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+
+	"example.com/mod/hashing"
+)
+
+// sizingIterations is the most times capacities are re-suggested, since
+// changing capacities changes where keys are placed.
+const sizingIterations = 3
+
+// sizingRow is the capacity suggested for a site by --targetUtil.
+type sizingRow struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name,omitempty"`
+	Capacity int    `json:"capacity"`
+	// Demand is the number of keys placed on the site with the suggested
+	// capacities, ignoring fullness.
+	Demand    int `json:"demand"`
+	Suggested int `json:"suggested"`
+}
+
+// suggestCapacities returns, for each of sites, the smallest capacity that
+// keeps the site's utilization at or under --targetUtil once keys are placed.
+// Each iteration places the distinct keys, ignoring fullness, on sites with
+// the last suggested capacities, starting from sites' own, and suggests new
+// ones from the keys each site was given. It stops once the suggestion is
+// stable or after sizingIterations, so the result is approximate.
+// Decommissioned sites stay at zero.
+func suggestCapacities(orig []*hashing.Site, hasher hashing.Hasher, keys []string) (rows []sizingRow, iterations int, err error) {
+	keys = distinctKeys(keys)
+	caps := make([]int, len(orig))
+	for i, s := range orig {
+		caps[i] = s.Capacity()
+	}
+	suggested := caps
+	for iterations < sizingIterations {
+		iterations++
+		sites := newSitesLike(orig, suggested)
+		ring, err := newPlacer(*algo, hasher, sites)
+		if err != nil {
+			return nil, 0, err
+		}
+		demand := placementDemand(ring, keys)
+
+		rows = rows[:0]
+		next := make([]int, len(sites))
+		for i, s := range sites {
+			if caps[i] > 0 {
+				next[i] = max(1, int(math.Ceil(float64(demand[s])/(*targetUtil))))
+			}
+			rows = append(rows, sizingRow{ID: s.ID(), Name: s.Name(), Capacity: caps[i], Demand: demand[s], Suggested: next[i]})
+		}
+		if equalCaps(next, suggested) {
+			break
+		}
+		suggested = next
+	}
+	return rows, iterations, nil
+}
+
+// placementDemand returns the number of keys placed on each of ring's sites,
+// counting every replica and ignoring fullness.
+func placementDemand(ring placer, keys []string) map[*hashing.Site]int {
+	sim := newSimulation(ring)
+	demand := make(map[*hashing.Site]int)
+	for _, key := range keys {
+		candidates := sim.candidates(key)
+		for _, s := range candidates[:min(*replicationFactor, len(candidates))] {
+			demand[s]++
+		}
+	}
+	return demand
+}
+
+func equalCaps(a, b []int) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return len(a) == len(b)
+}
+
+// printSizing writes rows as a table, or as JSON with --output json.
+func printSizing(w io.Writer, rows []sizingRow, iterations int) error {
+	if *output == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(rows)
+	}
+	fmt.Fprintf(w, "%-10s %-10s %-10s %s\n", "site", "capacity", "demand", "suggested")
+	for _, r := range rows {
+		fmt.Fprintf(w, "%-10s %-10d %-10d %d\n", siteLabel(r.ID, r.Name), r.Capacity, r.Demand, r.Suggested)
+	}
+	_, err := fmt.Fprintf(w, "suggested capacities keep each site at most %.0f%% full; they are approximate, since capacities move keys, after %d placement iterations\n", *targetUtil*100, iterations)
+	return err
+}
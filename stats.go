@@ -0,0 +1,230 @@
+package main
+
+import (
+	"math"
+	"sort"
+
+	"example.com/mod/hashing"
+)
+
+// loadStats returns the mean and standard deviation of the number of keys
+// stored per site, and the Gini coefficient of the sites' load-to-capacity
+// ratios, from the sites' collected stats. Using ratios for the Gini
+// coefficient means sites with uneven capacities don't look unbalanced just
+// because they hold different numbers of keys. Decommissioned sites, which
+// have no capacity, are left out.
+func loadStats(stats []SiteStat) (mean, stddev, gini float64) {
+	var active []SiteStat
+	for _, s := range stats {
+		if s.Capacity > 0 {
+			active = append(active, s)
+		}
+	}
+	if len(active) == 0 {
+		return 0, 0, 0
+	}
+	n := float64(len(active))
+
+	for _, s := range active {
+		mean += float64(s.Stored)
+	}
+	mean /= n
+	for _, s := range active {
+		d := float64(s.Stored) - mean
+		stddev += d * d
+	}
+	stddev = math.Sqrt(stddev / n)
+
+	var ratios []float64
+	var ratioSum float64
+	for _, s := range active {
+		r := float64(s.Stored) / float64(s.Capacity)
+		ratios = append(ratios, r)
+		ratioSum += r
+	}
+	if ratioSum == 0 {
+		return mean, stddev, 0
+	}
+	var diffSum float64
+	for _, a := range ratios {
+		for _, b := range ratios {
+			diffSum += math.Abs(a - b)
+		}
+	}
+	gini = diffSum / (2 * n * ratioSum)
+	return mean, stddev, gini
+}
+
+// hotspotFactor returns the id of the site with the highest fullness ratio in
+// sites' collected stats and the ratio of its fullness to the mean fullness
+// across sites. 1 means load is even; higher means the site is a hotspot.
+// Decommissioned sites are left out, and the factor is 0 if no site holds
+// anything.
+func hotspotFactor(stats []SiteStat) (worstID int, factor float64) {
+	var sum, worst float64
+	var n int
+	for _, s := range stats {
+		if s.Capacity <= 0 {
+			continue
+		}
+		r := float64(s.Stored) / float64(s.Capacity)
+		if n == 0 || r > worst {
+			worstID, worst = s.ID, r
+		}
+		sum += r
+		n++
+	}
+	if sum == 0 {
+		return worstID, 0
+	}
+	return worstID, worst / (sum / float64(n))
+}
+
+// loadPercentiles returns the ps'th percentiles, each between 0 and 100, of
+// the fullness ratios in sites' collected stats. Percentiles falling between
+// two sites are linearly interpolated. Decommissioned sites are left out.
+func loadPercentiles(stats []SiteStat, ps ...float64) map[float64]float64 {
+	var ratios []float64
+	for _, s := range stats {
+		if s.Capacity > 0 {
+			ratios = append(ratios, float64(s.Stored)/float64(s.Capacity))
+		}
+	}
+	return percentilesOf(ratios, ps...)
+}
+
+// capacitySharesPct returns the percentage of all capacity each of caps is,
+// which is the share of keys weighted rendezvous hashing should place on each
+// site. Negative capacities count as zero.
+func capacitySharesPct(caps []int) []float64 {
+	var total int64
+	for _, c := range caps {
+		total += int64(max(c, 0))
+	}
+	shares := make([]float64, len(caps))
+	if total == 0 {
+		return shares
+	}
+	for i, c := range caps {
+		shares[i] = float64(max(c, 0)) / float64(total) * 100
+	}
+	return shares
+}
+
+// sharesOf compares each site's share of capacity with its share of the key
+// copies stored.
+func sharesOf(stats []SiteStat) []shareRow {
+	caps := make([]int, len(stats))
+	var stored int
+	for i, s := range stats {
+		caps[i] = s.Capacity
+		stored += s.Stored
+	}
+	expected := capacitySharesPct(caps)
+	rows := make([]shareRow, len(stats))
+	for i, s := range stats {
+		rows[i] = shareRow{ID: s.ID, ExpectedPct: expected[i], ActualPct: pct(s.Stored, stored)}
+		rows[i].DeltaPct = rows[i].ActualPct - rows[i].ExpectedPct
+	}
+	return rows
+}
+
+// percentilesOf returns the ps'th percentiles, each between 0 and 100, of
+// values, linearly interpolating between values. values is sorted in place.
+func percentilesOf(values []float64, ps ...float64) map[float64]float64 {
+	sort.Float64s(values)
+	out := make(map[float64]float64, len(ps))
+	if len(values) == 0 {
+		return out
+	}
+	for _, p := range ps {
+		rank := p / 100 * float64(len(values)-1)
+		lo := int(math.Floor(rank))
+		hi := int(math.Ceil(rank))
+		out[p] = values[lo] + (values[hi]-values[lo])*(rank-float64(lo))
+	}
+	return out
+}
+
+// totalStored returns the number of keys stored across sites, counting each
+// replica.
+func totalStored(sites []*hashing.Site) int {
+	var n int
+	for _, s := range sites {
+		n += s.Stored()
+	}
+	return n
+}
+
+// distinctStored returns the number of distinct keys stored across sites,
+// counting each key once however many replicas hold it.
+func distinctStored(sites []*hashing.Site) int {
+	keys := make(map[string]struct{})
+	for _, s := range sites {
+		for _, k := range s.Keys() {
+			keys[k] = struct{}{}
+		}
+	}
+	return len(keys)
+}
+
+// copiesOf returns the number of key copies stored across sites' collected
+// stats, and the percentage of their total capacity those copies use.
+func copiesOf(stats []SiteStat) (copies int, capacityPct float64) {
+	var capacity int
+	for _, s := range stats {
+		copies += s.Stored
+		if s.Capacity > 0 {
+			capacity += s.Capacity
+		}
+	}
+	return copies, pct(copies, capacity)
+}
+
+// extrapolate scales the stats of a run on a sample of keys up to the of keys
+// they were sampled from.
+func extrapolate(res result, of int) *sampleResult {
+	scale := float64(of) / float64(res.Writes)
+	s := &sampleResult{
+		Keys:          res.Writes,
+		Of:            of,
+		Copies:        int(math.Round(float64(res.Copies) * scale)),
+		UnableToWrite: int(math.Round(float64(res.UnableToWrite) * scale)),
+	}
+	if res.Writes > 0 {
+		p := float64(res.UnableToWrite) / float64(res.Writes)
+		s.UnableToWritePct = p * 100
+		s.StdErrPct = math.Sqrt(p*(1-p)/float64(res.Writes)) * 100
+	}
+	return s
+}
+
+// storedSharePct returns the percentage of the keys stored across sites that
+// are stored on s.
+func storedSharePct(s *hashing.Site, sites []*hashing.Site) float64 {
+	total := totalStored(sites)
+	if total == 0 {
+		return 0
+	}
+	return float64(s.Stored()) / float64(total) * 100
+}
+
+// headroomAlerts records the sites that have crossed --alertAt fullness, in
+// the order they did.
+type headroomAlerts struct {
+	crossed []alertRow
+	seen    map[*hashing.Site]bool
+}
+
+// check alerts, once per site, if s has just crossed --alertAt fullness.
+func (a *headroomAlerts) check(sim *simulation, s *hashing.Site) {
+	if a.seen[s] || s.Capacity() <= 0 || sim.fullness(s) < *alertAt {
+		return
+	}
+	if a.seen == nil {
+		a.seen = make(map[*hashing.Site]bool)
+	}
+	a.seen[s] = true
+	a.crossed = append(a.crossed, alertRow{ID: s.ID(), Name: s.Name(), Order: len(a.crossed) + 1, Op: sim.op})
+	infof("alert: site %s crossed %.0f%% full at op %d\n", s.Label(), *alertAt*100, sim.op)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+
+	"example.com/mod/hashing"
+)
+
+// sweepRow is the outcome of the simulation at one replication factor.
+type sweepRow struct {
+	RF               int     `json:"rf"`
+	UnableToWritePct float64 `json:"unableToWritePct"`
+	ReadMissPct      float64 `json:"readMissPct"`
+	MeanStored       float64 `json:"meanStored"`
+	StddevStored     float64 `json:"stddevStored"`
+	Gini             float64 `json:"gini"`
+}
+
+// runSweep runs the simulation once for each replication factor from 1 to
+// --rfSweep and returns how each did. Every run starts from empty sites like
+// sites, reusing one ring reset between runs, and reads drawn from an rng
+// seeded with rngSeed, so runs differ only in replication factor.
+func runSweep(sites []*hashing.Site, hasher hashing.Hasher, keys []string, rngSeed int64) ([]sweepRow, error) {
+	defer func(rf int) { *replicationFactor = rf }(*replicationFactor)
+	caps := make([]int, len(sites))
+	for i, s := range sites {
+		caps[i] = s.Capacity()
+	}
+	ring, err := newPlacer(*algo, hasher, newSitesLike(sites, caps))
+	if err != nil {
+		return nil, err
+	}
+	var rows []sweepRow
+	for rf := 1; rf <= *rfSweep; rf++ {
+		*replicationFactor = rf
+		ring.Reset()
+		rng := rand.New(rand.NewSource(rngSeed))
+		nextWrite, err := newWriteDist(*writeDist, rng)
+		if err != nil {
+			return nil, err
+		}
+		nextReadKey, err := newReadDist(*readDist, keys, nextWrite, rng)
+		if err != nil {
+			return nil, err
+		}
+
+		sim := newSimulation(ring)
+		if *mixedOps > 0 {
+			err = sim.runMixed(keys, rng)
+		} else {
+			err = sim.run(keys, nextReadKey)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		res := sim.result()
+		row := sweepRow{RF: rf, MeanStored: res.MeanStored, StddevStored: res.StddevStored, Gini: res.Gini}
+		if res.Writes > 0 {
+			row.UnableToWritePct = float64(res.UnableToWrite) / float64(res.Writes) * 100
+		}
+		if res.Reads > 0 {
+			row.ReadMissPct = 100 - res.ReadHitPct
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// printSweep writes rows as a table, or as JSON with --output json.
+func printSweep(w io.Writer, rows []sweepRow) error {
+	if *output == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(rows)
+	}
+	fmt.Fprintf(w, "%-4s %-14s %-12s %-12s %-14s %s\n", "rf", "unable write", "read miss", "mean stored", "stddev stored", "gini")
+	var err error
+	for _, r := range rows {
+		_, err = fmt.Fprintf(w, "%-4d %-14s %-12s %-12.2f %-14.2f %.4f\n", r.RF, fmt.Sprintf("%.2f%%", r.UnableToWritePct), fmt.Sprintf("%.2f%%", r.ReadMissPct), r.MeanStored, r.StddevStored, r.Gini)
+	}
+	return err
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"example.com/mod/hashing"
+)
+
+// traceRecord is a write's placement decision, one per line of --traceFile.
+type traceRecord struct {
+	Key string `json:"key"`
+	// Candidates are the ids of the sites the write could go to, in
+	// preference order, and Placed those it went to.
+	Candidates []int `json:"candidates"`
+	Placed     []int `json:"placed"`
+	// Reason says why a rejected write couldn't be placed.
+	Rejected bool   `json:"rejected,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// tracer streams traceRecords to a file as newline delimited JSON, buffering
+// writes so that tracing large runs stays cheap and memory stays flat.
+type tracer struct {
+	f   *os.File
+	buf *bufio.Writer
+	enc *json.Encoder
+	err error
+}
+
+func newTracer(path string) (*tracer, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	buf := bufio.NewWriter(f)
+	return &tracer{f: f, buf: buf, enc: json.NewEncoder(buf)}, nil
+}
+
+// trace records the write of key to placed out of candidates. The first error
+// is kept for Close to return, and later records are dropped.
+func (t *tracer) trace(key string, candidates, placed []*hashing.Site, reason string) {
+	if t.err != nil {
+		return
+	}
+	rec := traceRecord{Key: key, Candidates: ids(candidates), Placed: ids(placed), Rejected: len(placed) == 0, Reason: reason}
+	t.err = t.enc.Encode(rec)
+}
+
+// Close flushes the trace and closes its file.
+func (t *tracer) Close() error {
+	if t.err == nil {
+		t.err = t.buf.Flush()
+	}
+	if err := t.f.Close(); t.err == nil {
+		t.err = err
+	}
+	if t.err != nil {
+		return fmt.Errorf("writing %s: %v", t.f.Name(), t.err)
+	}
+	return nil
+}
+
+// ids returns the ids of sites, never nil so that they encode as a JSON array.
+func ids(sites []*hashing.Site) []int {
+	out := make([]int, len(sites))
+	for i, s := range sites {
+		out[i] = s.ID()
+	}
+	return out
+}
@@ -0,0 +1,60 @@
+package main
+
+import "example.com/mod/hashing"
+
+// expiry drops key copies --ttl operations after they were written, freeing
+// their sites' capacity.
+type expiry struct {
+	ttl int
+	// written is the operation each site's copy of each key was last
+	// written at, and queue those writes in the order they were made, so
+	// the oldest expire first.
+	written map[*hashing.Site]map[string]int
+	queue   []expiring
+	// expired holds the keys that have had copies expire and haven't been
+	// written since, so a read missing them can be put down to expiry.
+	expired map[string]struct{}
+	// copies counts the key copies expired.
+	copies int
+}
+
+type expiring struct {
+	op   int
+	site *hashing.Site
+	key  string
+}
+
+func newExpiry(ttl int) *expiry {
+	return &expiry{
+		ttl:     ttl,
+		written: make(map[*hashing.Site]map[string]int),
+		expired: make(map[string]struct{}),
+	}
+}
+
+// stamp records that key was written to s at operation op.
+func (e *expiry) stamp(op int, s *hashing.Site, key string) {
+	if e.written[s] == nil {
+		e.written[s] = make(map[string]int)
+	}
+	e.written[s][key] = op
+	e.queue = append(e.queue, expiring{op: op, site: s, key: key})
+	delete(e.expired, key)
+}
+
+// expire drops the copies written --ttl or more operations before op, unless
+// they've been written again since.
+func (e *expiry) expire(op int) {
+	for len(e.queue) > 0 && e.queue[0].op+e.ttl <= op {
+		x := e.queue[0]
+		e.queue = e.queue[1:]
+		if at, ok := e.written[x.site][x.key]; !ok || at != x.op {
+			continue
+		}
+		delete(e.written[x.site], x.key)
+		if x.site.HandleDelete(x.key) {
+			e.copies++
+			e.expired[x.key] = struct{}{}
+		}
+	}
+}
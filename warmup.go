@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"example.com/mod/hashing"
+)
+
+// warmupRow is the cost under one algorithm of adding a site mid-run.
+type warmupRow struct {
+	Algo string `json:"algo"`
+	// Written counts the distinct keys written before the site was added.
+	Written int `json:"written"`
+	// RemappedPct is the percentage of them whose primary site changed, and
+	// IdealPct the new site's share of capacity, which is what minimal
+	// disruption would move.
+	RemappedPct float64 `json:"remappedPct"`
+	IdealPct    float64 `json:"idealPct"`
+	// WarmupCopies counts the copies written to warm the new site with the
+	// keys it's now a top --rf site for.
+	WarmupCopies int `json:"warmupCopies"`
+}
+
+// compareAddAlgos are the algorithms --compareAdd compares.
+var compareAddAlgos = []string{"rendezvous", "consistent"}
+
+// runCompareAdd, for each of compareAddAlgos, writes the first half of keys to
+// fresh sites like sites, adds a site of capacity capacity and returns how
+// many of the written keys moved and how many copies warming the new site
+// took. Every algorithm sees the same keys, so they differ only in placement.
+func runCompareAdd(sites []*hashing.Site, hasher hashing.Hasher, keys []string, capacity int) ([]warmupRow, error) {
+	caps := make([]int, len(sites))
+	var total int
+	for i, s := range sites {
+		caps[i] = s.Capacity()
+		total += s.Capacity()
+	}
+	written := keys[:len(keys)/2]
+	var rows []warmupRow
+	for _, name := range compareAddAlgos {
+		ring, err := newPlacer(name, hasher, newSitesLike(sites, caps))
+		if err != nil {
+			return nil, err
+		}
+		sim := newSimulation(ring)
+		for _, key := range written {
+			sim.write(key)
+		}
+		stored := sim.writtenKeys(written)
+
+		before := ring.Sites()
+		added := hashing.NewSite(nextSiteID(before), capacity)
+		ring.AddSite(added)
+		rows = append(rows, warmupRow{
+			Algo:         name,
+			Written:      len(stored),
+			RemappedPct:  ring.RemapFraction(stored, before, ring.Sites()) * 100,
+			IdealPct:     float64(capacity) / float64(total+capacity) * 100,
+			WarmupCopies: ring.WarmNewSite(added, stored, *replicationFactor),
+		})
+	}
+	return rows, nil
+}
+
+// printCompareAdd writes rows as a table, or as JSON with --output json.
+func printCompareAdd(w io.Writer, rows []warmupRow) error {
+	if *output == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(rows)
+	}
+	fmt.Fprintf(w, "%-12s %-10s %-10s %-10s %s\n", "algo", "written", "remapped", "ideal", "warmup copies")
+	var err error
+	for _, r := range rows {
+		_, err = fmt.Fprintf(w, "%-12s %-10d %-10s %-10s %d\n", r.Algo, r.Written, fmt.Sprintf("%.2f%%", r.RemappedPct), fmt.Sprintf("%.2f%%", r.IdealPct), r.WarmupCopies)
+	}
+	return err
+}